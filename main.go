@@ -3,18 +3,33 @@ package main
 import (
 	"flag"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/template"
+	"time"
 
 	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 
 	"srcd.works/go-git.v4"
-	gitssh "srcd.works/go-git.v4/plumbing/transport/ssh"
+	"srcd.works/go-git.v4/plumbing/transport"
 
 	"github.com/gorilla/mux"
 	"github.com/zyguan/just"
+
+	"github.com/zyguan/serv-repo/servrepo"
 )
 
 func logFatal(err error) error {
@@ -22,27 +37,140 @@ func logFatal(err error) error {
 	return nil
 }
 
-func logHandler(handler http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		log.Printf("%s %s from %s", r.Method, r.URL, r.RemoteAddr)
-		handler.ServeHTTP(w, r)
-	})
-}
-
 var (
-	gituser string
-	keypath string
-	sync    bool
-	port    int
+	gituser    string
+	keypath    string
+	authtype   string
+	knownhost  string
+	insecurehk bool
+	syncRemote bool
+	lazysync   bool
+	port       int
+	addr       string
+	unixsock   string
+	enableh2c  bool
+	errpages   string
+	layout     string
+	layoutfile string
+	pin        string
+	refttl     time.Duration
+	branches   string
+	zipmarker  string
+	threshold  int
+	cooldown   time.Duration
+	chkremote  bool
+	maxpath    int
+	maxbody    int64
+	pinginterv time.Duration
+	syncinterv time.Duration
+	syncjitter float64
+	misssync   time.Duration
+	checkout   bool
+	refmetrics int
+	readycache bool
+	coerce     bool
+	lowerkeys  bool
+	footercmt  string
+	passthru   bool
+	emptyerr   bool
+	missingkey string
+	debugcache bool
+	srvtiming  bool
+	validate   bool
+	allowext   string
+	checkext   string
+	deny       string
+	nocache    string
+	manifest   string
+	adminkey   string
+	adminlogs  int
+	maintain   bool
+	demo       bool
+	submodules bool
+	dataroutes routeList
+	exposehdrs string
+	hdrprefix  string
+	hdrwins    bool
+	respcache  int
+	respttl    time.Duration
+	accessfmt  string
+	corsorigin string
+	corsmethod string
+	maxconc    int
+	queuesize  int
+	rtimeout   time.Duration
+	wtimeout   time.Duration
+	itimeout   time.Duration
+	rndtimeout time.Duration
 )
 
 func init() {
 	home, _ := os.LookupEnv("HOME")
 
 	flag.StringVar(&gituser, "u", "git", "git user used to fetching the remote repo")
-	flag.StringVar(&keypath, "k", home+"/.ssh/id_rsa", "path to private key for authorization")
-	flag.BoolVar(&sync, "s", true, "sync remote when starting up")
+	flag.StringVar(&keypath, "k", home+"/.ssh/id_rsa", "path to private key for authorization, or comma separated paths of keys tried in order")
+	flag.StringVar(&knownhost, "known-hosts", home+"/.ssh/known_hosts", "known_hosts file used to verify the host key of an ssh remote")
+	flag.BoolVar(&insecurehk, "insecure-host-key", false, "skip verifying the host key of an ssh remote, which is open to MITM")
+	flag.StringVar(&authtype, "auth-type", "key-file", "how to authorize to the remote, key-file (with -k) or ssh-agent (via SSH_AUTH_SOCK)")
+	flag.BoolVar(&demo, "demo", false, "serve the built-in demo templates instead of a git repo, e.g. to try the server out")
+	flag.BoolVar(&syncRemote, "s", true, "sync remote when starting up")
+	flag.DurationVar(&syncinterv, "sync-interval", 0, "interval between periodic syncs of the remote, 0 to sync on demand only")
+	flag.DurationVar(&misssync, "miss-sync-timeout", 0, "time a request for a missing commit waits for the sync before a 504, 0 to wait until it's done")
+	flag.Float64Var(&syncjitter, "sync-jitter", 0.1, "fraction of -sync-interval each wait is randomly moved by, so servers started together don't sync at once")
+	flag.BoolVar(&lazysync, "skip-unchanged-sync", false, "list the remote refs before a sync and skip the fetch if no branch or tag moved")
+	flag.DurationVar(&pinginterv, "ping-interval", 10*time.Second, "min interval between remote checks done by /ping/git")
+	flag.BoolVar(&readycache, "readyz-cache-check", false, "round-trip a sentinel through the template cache backend on /readyz")
+	flag.BoolVar(&chkremote, "check-remote", false, "check the remote is reachable with the key when starting up")
 	flag.IntVar(&port, "p", 8080, "http port to listen on")
+	flag.StringVar(&unixsock, "unix", "", "path of a unix socket to listen on instead of tcp, excludes -p and -addr")
+	flag.StringVar(&addr, "addr", "", "host or host:port to bind to, e.g. 127.0.0.1, empty for all interfaces on -p")
+	flag.BoolVar(&enableh2c, "h2c", false, "serve HTTP/2 over cleartext besides HTTP/1.1, for TLS terminated upstream")
+	flag.DurationVar(&rtimeout, "read-timeout", 10*time.Second, "max duration of reading a request, 0 for no limit")
+	flag.DurationVar(&wtimeout, "write-timeout", 30*time.Second, "max duration of writing a response, 0 for no limit")
+	flag.DurationVar(&itimeout, "idle-timeout", 2*time.Minute, "max time a keep-alive connection waits for the next request, 0 for no limit")
+	flag.DurationVar(&rndtimeout, "render-timeout", 0, "budget of rendering a request, includes fail once it's exhausted, 0 for no limit")
+	flag.IntVar(&maxpath, "max-path", 0, "max length in bytes of a request path, e.g. 1024, 0 for no limit")
+	flag.Int64Var(&maxbody, "max-body", servrepo.DefaultMaxBodyBytes, "max size in bytes of a gzipped request body once decompressed")
+	flag.BoolVar(&lowerkeys, "case-insensitive-keys", false, "lowercase query keys, templates must refer to them in lowercase")
+	flag.BoolVar(&coerce, "coerce", false, "store query values looking like ints, floats or bools as typed values")
+	flag.StringVar(&missingkey, "missingkey", "error", "how templates handle keys missing from the data, error (a 400), zero, default or invalid")
+	flag.BoolVar(&passthru, "passthrough-on-parse-error", false, "serve files failing to parse as templates as is instead of failing")
+	flag.BoolVar(&emptyerr, "error-on-empty", false, "fail renders producing no output with a 422")
+	flag.StringVar(&footercmt, "footer-comment", "# ", "comment prefix of ?footer= lines when it can't be told from the file extension")
+	flag.StringVar(&exposehdrs, "expose-headers", "", "comma separated request headers templates can read via ._request.headers")
+	flag.StringVar(&hdrprefix, "header-data-prefix", "", "prefix of the request headers passed as data, e.g. X-Tmpl- makes X-Tmpl-Who .who, empty to disable")
+	flag.BoolVar(&hdrwins, "header-data-override", false, "let header data override query values of the same key")
+	flag.IntVar(&refmetrics, "ref-metrics", 0, "count requests per template ref at /metrics, up to that many refs and the rest as other, 0 to disable")
+	flag.StringVar(&accessfmt, "access-log-format", "text", "format of the access log lines, text (key=value pairs) or json")
+	flag.StringVar(&corsorigin, "cors-origins", "", "comma separated origins allowed to call the server from browsers, * for any, empty to disable CORS")
+	flag.StringVar(&corsmethod, "cors-methods", "GET,HEAD", "comma separated methods allowed by -cors-origins")
+	flag.IntVar(&maxconc, "max-concurrent", 0, "max number of requests served at once, 0 for no limit")
+	flag.IntVar(&queuesize, "queue-size", 64, "max number of requests waiting for -max-concurrent, more are rejected with 503")
+	flag.IntVar(&respcache, "response-cache", 0, "max number of rendered responses cached by url, 0 to disable")
+	flag.DurationVar(&respttl, "response-ttl", time.Minute, "how long a cached response is served")
+	flag.StringVar(&allowext, "allow-ext", "", "comma separated extensions of the files allowed to be served, e.g. .tmpl,.txt, empty for all")
+	flag.BoolVar(&submodules, "follow-submodules", false, "find files under submodules in their initialized repos in .git/modules")
+	flag.StringVar(&checkext, "parse-check-ext", "", "comma separated extensions of the files /_admin/parse-check parses by default, e.g. .tmpl, empty for all")
+	flag.StringVar(&deny, "deny", "", "comma separated glob patterns of the files forbidden to be served, e.g. **/secrets/**,*.key")
+	flag.Var(&dataroutes, "route", "route passing data in its path as pattern=file, e.g. /greet/{hash}/{who}=templates/hi.txt, repeatable")
+	flag.BoolVar(&maintain, "maintenance", false, "start in maintenance, skipping syncs and warning clients, can be switched via /_admin/maintenance")
+	flag.StringVar(&adminkey, "admin-secret", "", "bearer token of the /_admin routes, which are disabled if empty, defaults to $SERV_REPO_ADMIN_SECRET")
+	flag.IntVar(&adminlogs, "admin-logs", 0, "number of recent log lines kept for streaming via /_admin/logs, 0 to disable")
+	flag.StringVar(&manifest, "verify", "", "file listing hash::path refs, one per line, which must all be found at startup")
+	flag.StringVar(&nocache, "no-cache", "", "comma separated glob patterns of the templates loaded from git on every request instead of cached")
+	flag.BoolVar(&validate, "validate-data", false, "validate query data against the {template}.schema.json next to a template, if any")
+	flag.BoolVar(&srvtiming, "server-timing", false, "add Server-Timing headers breaking rendering down into phases")
+	flag.BoolVar(&debugcache, "debug-cache", false, "log evictions from the template cache")
+	flag.StringVar(&errpages, "error-pages", "", "dir of error templates named by status code, e.g. 404.html")
+	flag.StringVar(&layout, "layout", "", "path of a layout in the repo which templates are rendered into as {{ template \"content\" . }}")
+	flag.StringVar(&layoutfile, "layout-file", "", "like -layout, but a local file")
+	flag.StringVar(&branches, "branches", "", "comma separated branches to serve via /b/{branch}/raw/{path} and /b/{branch}/md5/{path}")
+	flag.StringVar(&pin, "pin", "", "commit, branch or tag to serve via /raw/{path} and /md5/{path}")
+	flag.BoolVar(&checkout, "checkout", false, "read the files of the -pin commit into memory, again when a sync moves it, and serve its templates from there")
+	flag.DurationVar(&refttl, "ref-ttl", 0, "how long -pin and -branches serve a resolved commit before syncing to resolve them again, 0 to resolve on syncs only")
+	flag.IntVar(&threshold, "breaker-threshold", 5, "consecutive fetch failures before sync is suspended, 0 to disable")
+	flag.DurationVar(&cooldown, "breaker-cooldown", 30*time.Second, "how long sync stays suspended once the breaker opens")
+	flag.StringVar(&zipmarker, "zip-marker", "---FILE: %s---", "marker line splitting the output of /zip into files")
 
 	flag.Usage = usage
 }
@@ -53,11 +181,18 @@ func usage() {
 	flag.PrintDefaults()
 	fmt.Fprintln(os.Stderr, "Examples:")
 	fmt.Fprintf(os.Stderr, "  %s -p=80 -s=false\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "  %s -addr=127.0.0.1:8080\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "  %s -demo\n", os.Args[0])
 }
 
 func main() {
 	defer just.CatchF(logFatal)(nil)
 	flag.Parse()
+	var logs *servrepo.LogBuffer
+	if adminlogs > 0 {
+		logs = just.TryTo("new log buffer: ")(servrepo.NewLogBuffer(adminlogs)).(*servrepo.LogBuffer)
+		log.SetOutput(io.MultiWriter(os.Stderr, logs))
+	}
 
 	repopath := "."
 	if len(flag.CommandLine.Args()) == 1 {
@@ -66,43 +201,347 @@ func main() {
 		usage()
 		os.Exit(1)
 	}
-	repo := openRepo(gituser, keypath, repopath, sync)
+	if len(unixsock) > 0 {
+		flag.Visit(func(f *flag.Flag) {
+			if f.Name == "p" || f.Name == "addr" {
+				log.Fatalf("-unix can't be used with -%s", f.Name)
+			}
+		})
+	}
+	just.TryTo("check -missingkey: ")(nil, servrepo.CheckMissingKey(missingkey))
+	breaker := servrepo.NewBreaker(threshold, cooldown)
+	maintenance := &servrepo.Maintenance{}
+	maintenance.Set(maintain)
+	var gitRepo *servrepo.GitTmplRepo
+	var repo servrepo.TmplRepo
+	if demo {
+		embedded := servrepo.NewDemoTmplRepo()
+		embedded.MissingKey = missingkey
+		repo = embedded
+		log.Printf("serve the built-in demo templates at any commit, e.g. /raw/%s/hi.txt?who=world", strings.Repeat("0", 40))
+	} else {
+		auth := just.TryTo("new auth provider: ")(servrepo.NewAuthProvider(authtype, gituser, keypath)).(servrepo.AuthProvider)
+		if insecurehk {
+			log.Print("WARNING: host key of the remote is not verified, the connection is open to MITM")
+			servrepo.InstallSSHTransport(ssh.InsecureIgnoreHostKey())
+		} else {
+			servrepo.InstallSSHTransport(just.TryTo("load known hosts: ")(knownhosts.New(knownhost)).(ssh.HostKeyCallback))
+		}
+		gitRepo, repo = openRepo(auth, repopath, syncRemote, pin, branches, breaker, maintenance)
+		if syncinterv > 0 {
+			periodic := just.TryTo("new periodic sync: ")(servrepo.NewPeriodicSync(repo, syncinterv, syncjitter)).(*servrepo.PeriodicSync)
+			go periodic.Run(nil)
+		}
+		if chkremote {
+			refs := just.TryTo("check remote: ")(gitRepo.ListRemote()).(map[string]string)
+			log.Printf("remote is reachable, %d refs advertised", len(refs))
+		}
+		if len(manifest) > 0 {
+			refs := just.TryTo("read manifest: ")(servrepo.ReadManifest(manifest)).([]servrepo.FileRef)
+			just.TryTo("verify manifest: ")(nil, gitRepo.Verify(refs))
+			log.Printf("all %d refs of %s are found", len(refs), manifest)
+		}
+	}
+	srv := servrepo.NewServer(repo)
+	if gitRepo != nil {
+		srv.Files = gitRepo
+		srv.RawFiles = gitRepo
+		srv.Sources = gitRepo
+		srv.Blobs = gitRepo
+		srv.Remote = gitRepo
+		srv.Whoami = gitRepo
+		srv.Tags = gitRepo
+		srv.History = gitRepo
+	} else {
+		srv.Sources = repo.(servrepo.SourceReader)
+	}
+	srv.PingInterval = pinginterv
+	srv.CoerceData = coerce
+	srv.CaseInsensitiveKeys = lowerkeys
+	srv.FooterComment = footercmt
+	srv.PassthroughOnParseError = passthru
+	srv.RenderTimeout = rndtimeout
+	srv.ErrorOnEmpty = emptyerr
+	srv.MaxBodyBytes = maxbody
+	srv.ServerTiming = srvtiming
+	srv.ValidateData = validate
+	if validate && !extAllowed(servrepo.SchemaSuffix) {
+		log.Fatal("-validate-data needs .json in -allow-ext to read the schemas")
+	}
+	srv.HeaderDataPrefix = hdrprefix
+	srv.HeaderDataOverride = hdrwins
+	if refmetrics > 0 {
+		srv.RefRequests = just.TryTo("new ref counter: ")(servrepo.NewRefCounter(refmetrics)).(*servrepo.RefCounter)
+	}
+	srv.AdminSecret = adminkey
+	srv.Logs = logs
+	srv.Maintenance = maintenance
+	srv.ParseCheckExt = splitPatterns(checkext)
+	if cached := findCache(repo); cached != nil {
+		srv.CacheKeys = cached
+		srv.CacheEvictions = cached
+		if readycache {
+			srv.ReadyCache = cached
+		}
+	}
+	if len(srv.AdminSecret) == 0 {
+		srv.AdminSecret = os.Getenv("SERV_REPO_ADMIN_SECRET")
+	}
+	if len(exposehdrs) > 0 {
+		srv.ExposeHeaders = strings.Split(exposehdrs, ",")
+	}
+	srv.FileMarker = just.TryTo("parse zip marker: ")(servrepo.ParseFileMarker(zipmarker)).(servrepo.FileMarker)
+	if len(errpages) > 0 {
+		srv.ErrorPages = just.TryTo("load error pages: ")(servrepo.LoadErrorPages(errpages)).(servrepo.ErrorPages)
+	}
+	srv.Layout = layout
+	if len(layoutfile) > 0 {
+		if len(layout) > 0 {
+			log.Fatal("-layout and -layout-file can't be used together")
+		}
+		srv.LocalLayout = just.TryTo("load layout: ")(servrepo.LoadLayout(layoutfile, missingkey)).(*template.Template)
+	}
 
 	r := mux.NewRouter()
-	r.PathPrefix("/raw/{hash:[0-9a-z]{40}}/").HandlerFunc(
-		RawHandler(repo, ExtractRefFromMuxVars),
-	)
-	r.PathPrefix("/md5/{hash:[0-9a-z]{40}}/").HandlerFunc(
-		MD5Handler(repo, ExtractRefFromMuxVars),
-	)
-	http.Handle("/", logHandler(r))
-	log.Printf("try to bind to 0.0.0.0:%d", port)
-	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", port), nil))
+	srv.Register(r)
+	r.NotFoundHandler = srv.NotFoundHandler()
+	inner := repo
+	if tracked, ok := inner.(*servrepo.BranchTmplRepo); ok {
+		r.Path("/b/{branch}/raw/" + servrepo.PathPattern).HandlerFunc(srv.RawHandler(tracked.ExtractRef))
+		r.Path("/b/{branch}/md5/" + servrepo.PathPattern).HandlerFunc(srv.MD5Handler(tracked.ExtractRef))
+		inner = tracked.TmplRepo
+	}
+	if pinned, ok := inner.(*servrepo.PinnedTmplRepo); ok {
+		r.Path("/raw/" + servrepo.PathPattern).HandlerFunc(srv.RawHandler(pinned.ExtractRef))
+		r.Path("/md5/" + servrepo.PathPattern).HandlerFunc(srv.MD5Handler(pinned.ExtractRef))
+	}
+	for _, route := range dataroutes {
+		just.TryTo("add route: ")(nil, addRoute(r, srv, route))
+	}
+	var handler http.Handler = servrepo.RecoverHandler(r)
+	if maxconc > 0 {
+		handler = just.TryTo("new concurrency limiter: ")(servrepo.NewConcurrencyLimiter(maxconc, queuesize)).(*servrepo.ConcurrencyLimiter).Handler(handler)
+	}
+	if respcache > 0 {
+		cache := just.TryTo("new response cache: ")(servrepo.NewResponseCache(respcache, respttl)).(*servrepo.ResponseCache)
+		cache.Refs = srv.RefRequests
+		handler = cache.Handler(handler)
+	}
+	accessLog := &servrepo.AccessLog{}
+	switch accessfmt {
+	case "text":
+	case "json":
+		accessLog.JSON = true
+		accessLog.Logger = log.New(os.Stderr, "", 0)
+	default:
+		log.Fatalf("unknown access log format %q, expect text or json", accessfmt)
+	}
+	handler = servrepo.MaxPathHandler(maxpath, servrepo.DegradedHandler(breaker, servrepo.MaintenanceHandler(maintenance, handler)))
+	if len(corsorigin) > 0 {
+		handler = servrepo.NewCORS(strings.Split(corsorigin, ","), strings.Split(corsmethod, ",")).Handler(handler)
+	}
+	http.Handle("/", accessLog.Handler(handler))
+	server := &http.Server{
+		Addr:         listenAddr(),
+		ReadTimeout:  rtimeout,
+		WriteTimeout: wtimeout,
+		IdleTimeout:  itimeout,
+	}
+	if enableh2c {
+		server.Handler = h2c.NewHandler(http.DefaultServeMux, &http2.Server{IdleTimeout: itimeout})
+	}
+	if len(unixsock) > 0 {
+		log.Printf("try to bind to unix socket %s", unixsock)
+		just.TryTo("serve on unix socket: ")(nil, serveUnix(server, unixsock))
+		return
+	}
+	log.Printf("try to bind to %s", listenAddr())
+	log.Fatal(server.ListenAndServe())
+}
+
+// serveUnix serves on a unix socket at path until the process is interrupted
+// or terminated, then the socket file is removed.
+func serveUnix(server *http.Server, path string) error {
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		log.Printf("%s received, shutting down", <-sigs)
+		// closing the listener removes the socket file
+		server.Close()
+	}()
+	if err = server.Serve(l); err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+// findCache finds the template cache under the branch and pin decorators.
+func findCache(repo servrepo.TmplRepo) *servrepo.CachedTmplRepo {
+	for {
+		switch r := repo.(type) {
+		case *servrepo.CachedTmplRepo:
+			return r
+		case *servrepo.BranchTmplRepo:
+			repo = r.TmplRepo
+		case *servrepo.PinnedTmplRepo:
+			repo = r.TmplRepo
+		default:
+			return nil
+		}
+	}
 }
 
-func openRepo(gitUser, keyPath, repoPath string, sync bool) TmplRepo {
-	// read private key
-	pem := just.TryTo("read key file: ")(ioutil.ReadFile(keyPath)).([]byte)
-	signer := just.TryTo("parse pem key: ")(ssh.ParsePrivateKey(pem)).(ssh.Signer)
-	key := &gitssh.PublicKeys{User: gitUser, Signer: signer}
+// routeList collects the repeated -route flags.
+type routeList []string
+
+func (l *routeList) String() string { return strings.Join(*l, " ") }
+
+func (l *routeList) Set(route string) error {
+	*l = append(*l, route)
+	return nil
+}
+
+var routeVarRegexp = regexp.MustCompile(`\{(\w+)(:[^}]*)?\}`)
+
+// addRoute registers a -route given as pattern=file. The {hash} var of the
+// pattern is the commit of the file, the other vars are data keys of the
+// same name.
+func addRoute(r *mux.Router, srv *servrepo.Server, route string) error {
+	pos := strings.LastIndex(route, "=")
+	if pos < 0 {
+		return fmt.Errorf("%q isn't pattern=file", route)
+	}
+	pattern, file := route[:pos], route[pos+1:]
+	if !strings.Contains(pattern, "{hash}") {
+		return fmt.Errorf("%q has no {hash}", route)
+	}
+	if srv.PathVars == nil {
+		srv.PathVars = make(map[string]string)
+	}
+	for _, m := range routeVarRegexp.FindAllStringSubmatch(pattern, -1) {
+		if m[1] != "hash" {
+			srv.PathVars[m[1]] = m[1]
+		}
+	}
+	pattern = strings.Replace(pattern, "{hash}", servrepo.HashPattern, 1)
+	r.Path(pattern).HandlerFunc(srv.RawHandler(servrepo.ExtractRefWithPath("hash", file)))
+	return nil
+}
+
+// listenAddr combines -addr and -p, a port in -addr wins over -p.
+func listenAddr() string {
+	if _, _, err := net.SplitHostPort(addr); err == nil {
+		return addr
+	}
+	return net.JoinHostPort(strings.Trim(addr, "[]"), strconv.Itoa(port))
+}
+
+// allowExts splits -allow-ext, a leading dot is added if missing.
+func allowExts() []string {
+	if len(allowext) == 0 {
+		return nil
+	}
+	exts := strings.Split(allowext, ",")
+	for i, ext := range exts {
+		if ext = strings.TrimSpace(ext); !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		exts[i] = ext
+	}
+	return exts
+}
+
+// extAllowed tells whether -allow-ext allows the files ending with suffix.
+func extAllowed(suffix string) bool {
+	exts := allowExts()
+	if len(exts) == 0 {
+		return true
+	}
+	for _, ext := range exts {
+		if strings.EqualFold(ext, path.Ext(suffix)) {
+			return true
+		}
+	}
+	return false
+}
+
+// splitPatterns splits comma separated glob patterns, e.g. -deny.
+func splitPatterns(list string) []string {
+	if len(list) == 0 {
+		return nil
+	}
+	patterns := strings.Split(list, ",")
+	for i, pattern := range patterns {
+		patterns[i] = strings.TrimSpace(pattern)
+	}
+	return patterns
+}
+
+// gitDir returns the .git dir of the repo at repoPath, or repoPath itself if
+// it's a bare repo.
+func gitDir(repoPath string) string {
+	dir := filepath.Join(repoPath, ".git")
+	if fi, err := os.Stat(dir); err == nil && fi.IsDir() {
+		return dir
+	}
+	return repoPath
+}
+
+func openRepo(auth servrepo.AuthProvider, repoPath string, sync bool, pin, branches string, breaker *servrepo.Breaker, maintenance *servrepo.Maintenance) (*servrepo.GitTmplRepo, servrepo.TmplRepo) {
+	// build auth
+	key := just.TryTo("build auth: ")(auth.Auth()).(transport.AuthMethod)
 
 	// open local git repo
 	local := just.TryTo("open local git repo: ")(git.PlainOpen(repoPath)).(*git.Repository)
-	gitRepo := &GitTmplRepo{Repository: local, Auth: key}
+	gitRepo := &servrepo.GitTmplRepo{Repository: local, Auth: key, Breaker: breaker, Maintenance: maintenance, SkipUnchanged: lazysync, AllowExt: allowExts(), Deny: splitPatterns(deny)}
+	gitRepo.MissingKey = missingkey
+	gitRepo.MissSyncTimeout = misssync
+	if submodules {
+		gitRepo.OpenSubmodule = servrepo.SubmoduleOpener(gitDir(repoPath))
+	}
 
 	// new tmpl repo
-	repo := just.TryTo("new cached tmpl repo: ")(NewCachedTmplRepo(gitRepo, 4096)).(TmplRepo)
+	cached := just.TryTo("new cached tmpl repo: ")(servrepo.NewCachedTmplRepo(gitRepo, 4096)).(*servrepo.CachedTmplRepo)
+	cached.LogEvictions = debugcache
+	cached.NoCache = splitPatterns(nocache)
+	var repo servrepo.TmplRepo = cached
 
+	// sync before resolving the pin and the branches, which may be new
 	if sync {
 		switch err := repo.Sync(); err {
 		case nil:
 			log.Print("repo has been updated")
 		case git.NoErrAlreadyUpToDate:
 			log.Print("repo is already up-to-date")
+		case servrepo.ErrMaintenance:
+			log.Print("skip syncing the repo during maintenance")
 		default:
 			log.Fatal("failed to fetch remote: ", err)
 		}
 	}
 
-	return repo
+	if len(pin) > 0 {
+		pinned := just.TryTo("resolve pin: ")(servrepo.NewPinnedTmplRepo(repo, gitRepo.ResolveRef, pin)).(*servrepo.PinnedTmplRepo)
+		pinned.TTL = refttl
+		repo = pinned
+		if checkout {
+			gitRepo.CheckoutRef = pin
+			if err := gitRepo.Checkout(); err != nil {
+				log.Fatal("failed to check out pin: ", err)
+			}
+		}
+	} else if checkout {
+		log.Fatal("-checkout can't be used without -pin")
+	}
+	if len(branches) > 0 {
+		tracked := just.TryTo("resolve branches: ")(servrepo.NewBranchTmplRepo(repo, gitRepo.ResolveRef, strings.Split(branches, ","))).(*servrepo.BranchTmplRepo)
+		tracked.TTL = refttl
+		repo = tracked
+	}
+
+	return gitRepo, repo
 }
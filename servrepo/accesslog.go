@@ -0,0 +1,192 @@
+package servrepo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LatencyBuckets are the upper bounds of the latency buckets of the access
+// log, a request slower than the last one falls into "+Inf".
+var LatencyBuckets = []time.Duration{
+	10 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	5 * time.Second,
+}
+
+// AccessInfo is what the handlers tell the access log about a request, it's
+// carried by the request context. A nil AccessInfo records nothing.
+type AccessInfo struct {
+	// Cache is "hit" if the response or the template came from a cache,
+	// "miss" if it had to be loaded, empty if no cache was involved.
+	Cache string
+	// Commit is the commit hash the response was rendered from.
+	Commit string
+}
+
+type accessInfoKey struct{}
+
+// WithAccessInfo returns r carrying a new AccessInfo for the handlers to fill.
+func WithAccessInfo(r *http.Request) (*http.Request, *AccessInfo) {
+	info := &AccessInfo{}
+	return r.WithContext(context.WithValue(r.Context(), accessInfoKey{}, info)), info
+}
+
+// AccessInfoOf returns the AccessInfo carried by r, nil if none.
+func AccessInfoOf(r *http.Request) *AccessInfo {
+	info, _ := r.Context().Value(accessInfoKey{}).(*AccessInfo)
+	return info
+}
+
+// SetCache records a cache hit or miss, a miss sticks, so a request served
+// from several templates is only a hit if all of them are.
+func (a *AccessInfo) SetCache(hit bool) {
+	if a == nil || a.Cache == "miss" {
+		return
+	}
+	if hit {
+		a.Cache = "hit"
+	} else {
+		a.Cache = "miss"
+	}
+}
+
+// SetCommit records the commit hash the response was rendered from.
+func (a *AccessInfo) SetCommit(hash string) {
+	if a == nil {
+		return
+	}
+	a.Commit = hash
+}
+
+// AccessLog logs a line per request with its method, path, status, bytes
+// written, duration, latency bucket, cache hit or miss and commit hash, as
+// key=value pairs or as a json object.
+type AccessLog struct {
+	// Logger writes the lines, nil means the standard logger.
+	Logger *log.Logger
+	JSON   bool
+}
+
+type accessEntry struct {
+	Time     string  `json:"time"`
+	Method   string  `json:"method"`
+	Path     string  `json:"path"`
+	Status   int     `json:"status"`
+	Bytes    int     `json:"bytes"`
+	Duration float64 `json:"duration_ms"`
+	Bucket   string  `json:"le"`
+	Cache    string  `json:"cache,omitempty"`
+	Commit   string  `json:"commit,omitempty"`
+	Remote   string  `json:"remote"`
+}
+
+// Handler logs the requests served by handler, which gets an AccessInfo in
+// the request context.
+func (l *AccessLog) Handler(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		r, info := WithAccessInfo(r)
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		handler.ServeHTTP(rec, r)
+		d := time.Since(start)
+		l.print(accessEntry{
+			Time:     start.UTC().Format(time.RFC3339Nano),
+			Method:   r.Method,
+			Path:     r.URL.Path,
+			Status:   rec.status,
+			Bytes:    rec.bytes,
+			Duration: float64(d) / float64(time.Millisecond),
+			Bucket:   latencyBucket(d),
+			Cache:    info.Cache,
+			Commit:   info.Commit,
+			Remote:   r.RemoteAddr,
+		})
+	})
+}
+
+func (l *AccessLog) print(e accessEntry) {
+	var line string
+	if l.JSON {
+		raw, _ := json.Marshal(e)
+		line = string(raw)
+	} else {
+		line = e.String()
+	}
+	if l.Logger == nil {
+		log.Print(line)
+		return
+	}
+	l.Logger.Print(line)
+}
+
+// String formats the entry as key=value pairs, the time is left to the logger.
+func (e accessEntry) String() string {
+	return strings.Join([]string{
+		"method=" + logValue(e.Method),
+		"path=" + logValue(e.Path),
+		"status=" + strconv.Itoa(e.Status),
+		"bytes=" + strconv.Itoa(e.Bytes),
+		fmt.Sprintf("duration=%.3fms", e.Duration),
+		"le=" + e.Bucket,
+		"cache=" + logValue(e.Cache),
+		"commit=" + logValue(e.Commit),
+		"remote=" + logValue(e.Remote),
+	}, " ")
+}
+
+// logValue quotes v if it's empty or would break the key=value pairs apart.
+func logValue(v string) string {
+	if len(v) == 0 {
+		return "-"
+	}
+	if strings.ContainsAny(v, " \"=\t\r\n") {
+		return strconv.Quote(v)
+	}
+	return v
+}
+
+func latencyBucket(d time.Duration) string {
+	for _, le := range LatencyBuckets {
+		if d <= le {
+			return le.String()
+		}
+	}
+	return "+Inf"
+}
+
+// statusRecorder records the status and the number of bytes written through
+// it.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(p []byte) (int, error) {
+	n, err := r.ResponseWriter.Write(p)
+	r.bytes += n
+	return n, err
+}
+
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets an http.ResponseController reach the underlying writer.
+func (r *statusRecorder) Unwrap() http.ResponseWriter { return r.ResponseWriter }
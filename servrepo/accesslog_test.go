@@ -0,0 +1,71 @@
+package servrepo
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAccessLog(t *testing.T) {
+	var buf bytes.Buffer
+	access := &AccessLog{Logger: log.New(&buf, "", 0)}
+	handler := access.Handler(NewServer(repo(t, "..", 32)).Handler())
+	lastLine := func(path string) string {
+		buf.Reset()
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+		return strings.TrimSpace(buf.String())
+	}
+
+	path := "/raw/" + INIT_COMMIT + "/templates/hi.txt"
+	line := lastLine(path + "?who=world")
+	assert.Contains(t, line, "method=GET path="+path+" status=200 bytes=11 duration=")
+	assert.Contains(t, line, " cache=miss commit="+INIT_COMMIT+" remote=192.0.2.1:1234")
+	assert.Regexp(t, ` le=(\d+[mµn]?s|\+Inf) `, line)
+	assert.Contains(t, lastLine(path+"?who=world"), " cache=hit ")
+	assert.Contains(t, lastLine("/no/such/route"), "status=404 bytes=14 ")
+	assert.Contains(t, lastLine("/no/such/route"), " cache=- commit=- ")
+
+	access.JSON = true
+	var entry map[string]interface{}
+	assert.NoError(t, json.Unmarshal([]byte(lastLine(path+"?who=world")), &entry))
+	assert.Equal(t, "GET", entry["method"])
+	assert.Equal(t, path, entry["path"])
+	assert.Equal(t, float64(200), entry["status"])
+	assert.Equal(t, "hit", entry["cache"])
+	assert.Equal(t, INIT_COMMIT, entry["commit"])
+}
+
+func TestAccessLogResponseCache(t *testing.T) {
+	var buf bytes.Buffer
+	cache, err := NewResponseCache(8, time.Minute)
+	assert.NoError(t, err)
+	access := &AccessLog{Logger: log.New(&buf, "", 0)}
+	handler := access.Handler(cache.Handler(NewServer(repo(t, "..", 0)).Handler()))
+
+	url := "/raw/" + INIT_COMMIT + "/templates/hi.txt?who=world"
+	for _, cached := range []string{"-", "hit"} {
+		buf.Reset()
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", url, nil))
+		assert.Contains(t, buf.String(), " cache="+cached+" ")
+	}
+}
+
+func TestLatencyBucket(t *testing.T) {
+	assert.Equal(t, "10ms", latencyBucket(time.Millisecond))
+	assert.Equal(t, "10ms", latencyBucket(10*time.Millisecond))
+	assert.Equal(t, "500ms", latencyBucket(200*time.Millisecond))
+	assert.Equal(t, "+Inf", latencyBucket(time.Minute))
+}
+
+func TestLogValue(t *testing.T) {
+	assert.Equal(t, "-", logValue(""))
+	assert.Equal(t, "/raw/x", logValue("/raw/x"))
+	assert.Equal(t, `"a b"`, logValue("a b"))
+	assert.Equal(t, `"a=\"b\""`, logValue(`a="b"`))
+}
@@ -0,0 +1,108 @@
+package servrepo
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// MaxPrefetchBody caps the size of the body of /_admin/prefetch.
+const MaxPrefetchBody = 1 << 20
+
+// ErrNotAdmin is the 401 error of admin requests without the admin secret.
+var ErrNotAdmin = errors.New("admin secret is missing or wrong")
+
+// adminHandler lets through the requests carrying the AdminSecret as a
+// bearer token, e.g. "Authorization: Bearer s3cret", a bare secret isn't
+// accepted. Admin responses are never to be cached.
+func (s *Server) adminHandler(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		bearer := strings.HasPrefix(auth, "Bearer ")
+		token := strings.TrimPrefix(auth, "Bearer ")
+		if len(s.AdminSecret) == 0 || !bearer || subtle.ConstantTimeCompare([]byte(token), []byte(s.AdminSecret)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			s.checkFailure(ErrNotAdmin, http.StatusUnauthorized, w)
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		handler(w, r)
+	}
+}
+
+type prefetchResult struct {
+	Ref   string `json:"ref"`
+	Error string `json:"error,omitempty"`
+}
+
+type prefetchSummary struct {
+	OK      int              `json:"ok"`
+	Failed  int              `json:"failed"`
+	Results []prefetchResult `json:"results"`
+}
+
+// PrefetchHandler gets the templates of the hash::path lines of the body,
+// syncing for missing commits, so they're cached before traffic arrives. It
+// reports the outcome of every ref as json.
+func (s *Server) PrefetchHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		refs, err := parseManifest(http.MaxBytesReader(w, r.Body, MaxPrefetchBody), "body")
+		if s.checkFailure(err, http.StatusBadRequest, w) {
+			return
+		}
+		summary := prefetchSummary{Results: make([]prefetchResult, len(refs))}
+		for i, ref := range refs {
+			summary.Results[i].Ref = ref.String()
+			if _, err := s.Repo.GetTemplate(ref, true); err != nil {
+				summary.Results[i].Error = err.Error()
+				summary.Failed++
+			} else {
+				summary.OK++
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(summary)
+	}
+}
+
+// KeyLister lists the keys of a cache, see CachedTmplRepo.Keys.
+type KeyLister interface {
+	Keys() []string
+}
+
+// MaxCacheKeys caps the number of keys /_admin/cache/keys lists.
+const MaxCacheKeys = 1000
+
+type cacheKeys struct {
+	Total int      `json:"total"`
+	Keys  []string `json:"keys"`
+}
+
+// CacheKeysHandler lists the keys of the cache as json, the most recently
+// used first, at most MaxCacheKeys or ?limit= of them.
+func (s *Server) CacheKeysHandler(cache KeyLister) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		limit := MaxCacheKeys
+		if v := r.FormValue("limit"); len(v) > 0 {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				s.checkFailure(fmt.Errorf("invalid limit %q", v), http.StatusBadRequest, w)
+				return
+			}
+			if n < limit {
+				limit = n
+			}
+		}
+		keys := cache.Keys()
+		resp := cacheKeys{Total: len(keys), Keys: make([]string, 0, limit)}
+		for i := len(keys) - 1; i >= 0 && len(resp.Keys) < limit; i-- {
+			resp.Keys = append(resp.Keys, keys[i])
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	}
+}
@@ -0,0 +1,138 @@
+package servrepo
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+
+	"srcd.works/go-git.v4/plumbing/transport"
+	gitssh "srcd.works/go-git.v4/plumbing/transport/ssh"
+)
+
+// AuthProvider provides the auth used to talk to the remote.
+type AuthProvider interface {
+	Auth() (transport.AuthMethod, error)
+}
+
+// KeyFileAuth authenticates as User with the private key at Path. Path may
+// be a comma separated list of keys, e.g. during a key rotation, which are
+// offered to the remote in order; keys failing to load are skipped as long as
+// one of them loads.
+type KeyFileAuth struct {
+	User string
+	Path string
+}
+
+func (a KeyFileAuth) Auth() (transport.AuthMethod, error) {
+	paths := strings.Split(a.Path, ",")
+	if len(paths) == 1 {
+		signer, err := loadKey(a.Path)
+		if err != nil {
+			return nil, err
+		}
+		return &gitssh.PublicKeys{User: a.User, Signer: signer}, nil
+	}
+	var signers []ssh.Signer
+	for _, path := range paths {
+		signer, err := loadKey(path)
+		if err != nil {
+			log.Printf("skip key %s: %v", path, err)
+			continue
+		}
+		if as, ok := signer.(ssh.AlgorithmSigner); ok {
+			signer = keyAlgorithmSigner{keySigner{Signer: as, path: path}, as}
+		} else {
+			signer = keySigner{Signer: signer, path: path}
+		}
+		signers = append(signers, signer)
+	}
+	if len(signers) == 0 {
+		return nil, fmt.Errorf("none of the keys can be loaded: %s", a.Path)
+	}
+	return &gitssh.PublicKeysCallback{User: a.User, Callback: func() ([]ssh.Signer, error) { return signers, nil }}, nil
+}
+
+func loadKey(path string) (ssh.Signer, error) {
+	pem, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read key file: %v", err)
+	}
+	signer, err := ssh.ParsePrivateKey(pem)
+	if err != nil {
+		return nil, fmt.Errorf("parse pem key: %v", err)
+	}
+	return signer, nil
+}
+
+// keySigner logs the path of the key when it signs, which the client only
+// does once the remote has accepted the key, so the log tells which of the
+// keys is in use.
+type keySigner struct {
+	ssh.Signer
+	path string
+}
+
+func (s keySigner) Sign(rand io.Reader, data []byte) (*ssh.Signature, error) {
+	log.Printf("authenticate with key %s", s.path)
+	return s.Signer.Sign(rand, data)
+}
+
+// keyAlgorithmSigner keeps a key able to sign with other algorithms, e.g.
+// rsa-sha2-256 for RSA keys, able to do so once wrapped.
+type keyAlgorithmSigner struct {
+	keySigner
+	as ssh.AlgorithmSigner
+}
+
+func (s keyAlgorithmSigner) SignWithAlgorithm(rand io.Reader, data []byte, algorithm string) (*ssh.Signature, error) {
+	log.Printf("authenticate with key %s", s.path)
+	return s.as.SignWithAlgorithm(rand, data, algorithm)
+}
+
+// ErrNoSSHAgent is returned by SSHAgentAuth if SSH_AUTH_SOCK isn't set.
+var ErrNoSSHAgent = errors.New("SSH_AUTH_SOCK is not set, is the ssh agent running?")
+
+// SSHAgentAuth authenticates as User with the keys held by the ssh agent
+// listening on SSH_AUTH_SOCK, so no key has to be placed on disk.
+type SSHAgentAuth struct {
+	User string
+}
+
+func (a SSHAgentAuth) Auth() (transport.AuthMethod, error) {
+	if len(os.Getenv("SSH_AUTH_SOCK")) == 0 {
+		return nil, ErrNoSSHAgent
+	}
+	auth, err := gitssh.NewSSHAgentAuth(a.User)
+	if err != nil {
+		return nil, fmt.Errorf("connect to ssh agent: %v", err)
+	}
+	return auth, nil
+}
+
+// AuthProviders maps auth types to the constructors of their providers, which
+// take the git user and the key path.
+var AuthProviders = map[string]func(user, keyPath string) AuthProvider{
+	"key-file":  func(user, keyPath string) AuthProvider { return KeyFileAuth{User: user, Path: keyPath} },
+	"ssh-agent": func(user, keyPath string) AuthProvider { return SSHAgentAuth{User: user} },
+}
+
+// NewAuthProvider returns the provider of the auth type.
+func NewAuthProvider(authType, user, keyPath string) (AuthProvider, error) {
+	newProvider, ok := AuthProviders[authType]
+	if !ok {
+		types := make([]string, 0, len(AuthProviders))
+		for t := range AuthProviders {
+			types = append(types, t)
+		}
+		sort.Strings(types)
+		return nil, fmt.Errorf("unknown auth type %q, expect one of %s", authType, strings.Join(types, ", "))
+	}
+	return newProvider(user, keyPath), nil
+}
@@ -0,0 +1,85 @@
+package servrepo
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+
+	gitssh "srcd.works/go-git.v4/plumbing/transport/ssh"
+)
+
+func TestNewAuthProvider(t *testing.T) {
+	p, err := NewAuthProvider("key-file", "git", "no/such/key")
+	assert.NoError(t, err)
+	assert.Equal(t, KeyFileAuth{User: "git", Path: "no/such/key"}, p)
+	_, err = p.Auth()
+	assert.Error(t, err)
+
+	p, err = NewAuthProvider("ssh-agent", "git", "no/such/key")
+	assert.NoError(t, err)
+	assert.Equal(t, SSHAgentAuth{User: "git"}, p)
+	defer os.Setenv("SSH_AUTH_SOCK", os.Getenv("SSH_AUTH_SOCK"))
+	os.Unsetenv("SSH_AUTH_SOCK")
+	_, err = p.Auth()
+	assert.Equal(t, ErrNoSSHAgent, err)
+
+	_, err = NewAuthProvider("password", "git", "")
+	assert.EqualError(t, err, `unknown auth type "password", expect one of key-file, ssh-agent`)
+}
+
+func TestKeyFileAuthMultipleKeys(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keys")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	var keys []ssh.Signer
+	for _, name := range []string{"old", "new"} {
+		_, priv, err := ed25519.GenerateKey(rand.Reader)
+		assert.NoError(t, err)
+		block, err := ssh.MarshalPrivateKey(priv, "")
+		assert.NoError(t, err)
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), pem.EncodeToMemory(block), 0600))
+		signer, err := ssh.NewSignerFromKey(priv)
+		assert.NoError(t, err)
+		keys = append(keys, signer)
+	}
+	missing, oldKey, newKey := filepath.Join(dir, "missing"), filepath.Join(dir, "old"), filepath.Join(dir, "new")
+
+	_, err = KeyFileAuth{User: "git", Path: missing + "," + missing}.Auth()
+	assert.Error(t, err)
+
+	auth, err := KeyFileAuth{User: "git", Path: missing + "," + oldKey + "," + newKey}.Auth()
+	assert.NoError(t, err)
+	callback, ok := auth.(*gitssh.PublicKeysCallback)
+	if !assert.True(t, ok) {
+		return
+	}
+	signers, err := callback.Callback()
+	assert.NoError(t, err)
+	assert.Len(t, signers, 2)
+
+	// the remote only accepts the new key
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	addr, _, stop := sshServer(t, keys[1].PublicKey())
+	defer stop()
+	conn, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+		User:            "git",
+		Auth:            []ssh.AuthMethod{ssh.PublicKeysCallback(callback.Callback)},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	if assert.NoError(t, err) {
+		conn.Close()
+	}
+	assert.Contains(t, buf.String(), "authenticate with key "+newKey)
+	assert.NotContains(t, buf.String(), "authenticate with key "+oldKey)
+}
@@ -0,0 +1,26 @@
+package servrepo
+
+import (
+	"log"
+	"net/http"
+)
+
+// BlobHasher tells the hash of the git blob of a file, see
+// GitTmplRepo.BlobHash.
+type BlobHasher interface {
+	BlobHash(ref FileRef) (string, error)
+}
+
+// setBlobHash sets the X-Blob-Hash header to the blob hash of ref, if Blobs
+// is set. The file has been rendered already, so a failure is only logged.
+func (s *Server) setBlobHash(w http.ResponseWriter, ref FileRef) {
+	if s.Blobs == nil {
+		return
+	}
+	hash, err := s.Blobs.BlobHash(ref)
+	if err != nil {
+		log.Printf("failed to get blob hash of %s: %v", ref.String(), err)
+		return
+	}
+	w.Header().Set("X-Blob-Hash", hash)
+}
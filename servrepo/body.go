@@ -0,0 +1,53 @@
+package servrepo
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// ErrBodyTooLarge is returned for a request body larger than
+// Server.MaxBodyBytes once decompressed.
+var ErrBodyTooLarge = errors.New("request body is too large")
+
+// DefaultMaxBodyBytes is the default of Server.MaxBodyBytes, the same as the
+// limit of form bodies of net/http.
+const DefaultMaxBodyBytes = 10 << 20
+
+// decodeBody replaces a gzipped body of r by its decompressed content, so
+// it's parsed like a plain one. The limit applies to the decompressed size,
+// so a small body can't expand without bound.
+func decodeBody(r *http.Request, limit int64) error {
+	switch enc := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding"))); enc {
+	case "", "identity":
+		return nil
+	case "gzip":
+	default:
+		return fmt.Errorf("unsupported request Content-Encoding %q, expect gzip", enc)
+	}
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil
+	}
+	zr, err := gzip.NewReader(r.Body)
+	if err != nil {
+		return fmt.Errorf("malformed gzip body: %v", err)
+	}
+	defer zr.Close()
+	raw, err := ioutil.ReadAll(io.LimitReader(zr, limit+1))
+	if err != nil {
+		return fmt.Errorf("malformed gzip body: %v", err)
+	}
+	if int64(len(raw)) > limit {
+		return ErrBodyTooLarge
+	}
+	r.Body.Close()
+	r.Body = ioutil.NopCloser(bytes.NewReader(raw))
+	r.ContentLength = int64(len(raw))
+	r.Header.Del("Content-Encoding")
+	return nil
+}
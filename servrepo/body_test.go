@@ -0,0 +1,50 @@
+package servrepo
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGzipBody(t *testing.T) {
+	s := server(repo(t, "..", 32), func(s *Server) { s.MaxBodyBytes = 64 })
+	defer s.Close()
+	post := func(body []byte, gzipped bool) (int, string) {
+		req, _ := http.NewRequest("POST", s.URL+"/raw/"+INIT_COMMIT+"/templates/hi.txt", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if gzipped {
+			req.Header.Set("Content-Encoding", "gzip")
+		}
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		out, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, string(out)
+	}
+	gzipped := func(raw string) []byte {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		zw.Write([]byte(raw))
+		zw.Close()
+		return buf.Bytes()
+	}
+
+	status, body := post(gzipped("who=world"), true)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "Hi, world!\n", body)
+	status, body = post([]byte("who=world"), false)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "Hi, world!\n", body)
+
+	status, _ = post([]byte("who=world"), true)
+	assert.Equal(t, http.StatusBadRequest, status)
+	// compresses well, but is too large once decompressed
+	bomb := gzipped("who=" + strings.Repeat("a", 1024))
+	assert.True(t, len(bomb) < 64)
+	status, _ = post(bomb, true)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, status)
+}
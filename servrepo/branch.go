@@ -0,0 +1,95 @@
+package servrepo
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"srcd.works/go-git.v4"
+)
+
+// ErrBranchNotTracked is returned for a branch not in BranchTmplRepo.Branches.
+var ErrBranchNotTracked = errors.New("the branch is not tracked")
+
+// BranchTmplRepo is a TmplRepo tracking the commits a fixed set of branches
+// point to, the branches are resolved when the repo is created and again after
+// every sync. Refs are made of the resolved commits, so cached templates are
+// shared with requests by hash.
+type BranchTmplRepo struct {
+	TmplRepo
+	Branches []string
+	// TTL is how long the resolved commits are served before a request
+	// syncs to resolve the branches again, 0 means they're resolved again on
+	// syncs only.
+	TTL     time.Duration
+	resolve func(ref string) (string, error)
+	refresh refresher
+
+	mu      sync.RWMutex
+	commits map[string]string
+}
+
+func NewBranchTmplRepo(repo TmplRepo, resolve func(ref string) (string, error), branches []string) (*BranchTmplRepo, error) {
+	r := &BranchTmplRepo{TmplRepo: repo, Branches: branches, resolve: resolve}
+	if err := r.Resolve(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Resolve resolves all the tracked branches again, the commits are updated
+// only if all of them are resolved.
+func (r *BranchTmplRepo) Resolve() error {
+	commits := make(map[string]string, len(r.Branches))
+	for _, branch := range r.Branches {
+		hash, err := r.resolve(branch)
+		if err != nil {
+			return err
+		}
+		commits[branch] = hash
+	}
+	r.mu.Lock()
+	r.commits = commits
+	r.mu.Unlock()
+	r.refresh.resolvedNow()
+	return nil
+}
+
+// Commit returns the commit hash the branch currently points to.
+func (r *BranchTmplRepo) Commit(branch string) (string, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	hash, ok := r.commits[branch]
+	return hash, ok
+}
+
+func (r *BranchTmplRepo) Sync() error {
+	err := r.TmplRepo.Sync()
+	if err != nil && err != git.NoErrAlreadyUpToDate {
+		return err
+	}
+	if e := r.Resolve(); e != nil {
+		return e
+	}
+	return err
+}
+
+// ExtractRef is an extractor which takes the branch and the file path from
+// the "branch" and "path" vars of the route and pairs the path with the
+// commit of the branch, the branches are resolved again first if they're older
+// than TTL.
+func (r *BranchTmplRepo) ExtractRef(req *http.Request) (FileRef, error) {
+	vars := mux.Vars(req)
+	path, ok := vars["path"]
+	if !ok {
+		return FileRef{}, errors.New("route has no \"path\" var")
+	}
+	r.refresh.refresh(r.TTL, "branches", r.Sync)
+	hash, ok := r.Commit(vars["branch"])
+	if !ok {
+		return FileRef{}, ErrBranchNotTracked
+	}
+	return FileRef{CommitHash: hash, FilePath: path}, nil
+}
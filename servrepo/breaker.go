@@ -0,0 +1,94 @@
+package servrepo
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var ErrSyncSuspended = errors.New("sync is suspended after consecutive failures")
+
+// Breaker is a circuit breaker for git fetches. It opens after Threshold
+// consecutive failures and stays open for Cooldown, after which a single
+// attempt is let through again: the breaker is half open until that attempt
+// is recorded, closing it on success or opening it for another Cooldown on
+// failure. A nil *Breaker never opens.
+type Breaker struct {
+	Threshold int
+	Cooldown  time.Duration
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+func NewBreaker(threshold int, cooldown time.Duration) *Breaker {
+	if threshold <= 0 {
+		return nil
+	}
+	return &Breaker{Threshold: threshold, Cooldown: cooldown}
+}
+
+// Allow reports whether an operation may be attempted now. Once the cooldown
+// is over, only the first caller is allowed until its result is recorded.
+func (b *Breaker) Allow() bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.open() {
+		return false
+	}
+	if !b.openUntil.IsZero() {
+		b.probing = true
+	}
+	return true
+}
+
+// Open reports whether the breaker is currently open, i.e. degraded.
+func (b *Breaker) Open() bool {
+	if b == nil {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.open()
+}
+
+func (b *Breaker) open() bool {
+	return b.probing || time.Now().Before(b.openUntil)
+}
+
+// Record records the result of an attempted operation, a success closes the
+// breaker and resets the failure count.
+func (b *Breaker) Record(ok bool) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+	if ok {
+		b.failures = 0
+		b.openUntil = time.Time{}
+		return
+	}
+	b.failures++
+	if b.failures >= b.Threshold {
+		b.openUntil = time.Now().Add(b.Cooldown)
+	}
+}
+
+// DegradedHandler marks responses with an X-Degraded header while the
+// breaker is open.
+func DegradedHandler(b *Breaker, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if b.Open() {
+			w.Header().Set("X-Degraded", "git-sync-suspended")
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,64 @@
+package servrepo
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBreaker(t *testing.T) {
+	var nilBreaker *Breaker
+	assert.True(t, nilBreaker.Allow())
+	nilBreaker.Record(false)
+	assert.Nil(t, NewBreaker(0, time.Second))
+
+	b := NewBreaker(2, 50*time.Millisecond)
+	b.Record(false)
+	assert.True(t, b.Allow())
+	b.Record(false)
+	assert.False(t, b.Allow())
+	assert.True(t, b.Open())
+
+	// half open after the cooldown, a failure opens it again at once
+	time.Sleep(60 * time.Millisecond)
+	assert.False(t, b.Open())
+	assert.True(t, b.Allow())
+	assert.False(t, b.Allow(), "only a single attempt is let through")
+	assert.True(t, b.Open())
+	b.Record(false)
+	assert.False(t, b.Allow())
+
+	time.Sleep(60 * time.Millisecond)
+	assert.True(t, b.Allow())
+	assert.False(t, b.Allow())
+	b.Record(true)
+	assert.True(t, b.Allow())
+	assert.True(t, b.Allow())
+	b.Record(false)
+	assert.True(t, b.Allow())
+}
+
+func TestDegradedHandler(t *testing.T) {
+	b := NewBreaker(1, time.Minute)
+	h := DegradedHandler(b, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	assert.Empty(t, w.Header().Get("X-Degraded"))
+
+	b.Record(false)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, "git-sync-suspended", w.Header().Get("X-Degraded"))
+}
+
+func TestSyncWithBreaker(t *testing.T) {
+	r := repo(t, "..", 0).(*GitTmplRepo)
+	r.Breaker = NewBreaker(1, time.Minute)
+	r.Breaker.Record(false)
+
+	assert.Equal(t, ErrSyncSuspended, r.Sync())
+}
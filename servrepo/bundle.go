@@ -0,0 +1,135 @@
+package servrepo
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// FileMarker describes the delimiter lines which split a rendered output into
+// named files. A marker is a whole line of Prefix + name + Suffix, e.g.
+//
+//	---FILE: conf/app.ini---
+//
+// Everything following a marker up to the next one (or the end) belongs to
+// the named file. Text before the first marker must be blank, and an output
+// without any marker yields a single file named after the template.
+type FileMarker struct {
+	Prefix string
+	Suffix string
+}
+
+var DefaultFileMarker = FileMarker{Prefix: "---FILE: ", Suffix: "---"}
+
+// ParseFileMarker parses a marker format like "---FILE: %s---", where %s
+// stands for the file name.
+func ParseFileMarker(format string) (FileMarker, error) {
+	parts := strings.Split(format, "%s")
+	if len(parts) != 2 || len(parts[0]) == 0 {
+		return FileMarker{}, fmt.Errorf("invalid file marker %q: want a non-empty prefix and exactly one %%s", format)
+	}
+	return FileMarker{Prefix: parts[0], Suffix: parts[1]}, nil
+}
+
+type BundleFile struct {
+	Name    string
+	Content []byte
+}
+
+// Split splits out into files, name is used when there is no marker at all.
+func (m FileMarker) Split(name string, out []byte) ([]BundleFile, error) {
+	var (
+		files []BundleFile
+		lead  []byte
+		seen  = make(map[string]bool)
+	)
+	for len(out) > 0 {
+		line := out
+		if i := bytes.IndexByte(out, '\n'); i >= 0 {
+			line = out[:i+1]
+		}
+		out = out[len(line):]
+
+		if fname, ok := m.match(line); ok {
+			if !validBundleName(fname) {
+				return nil, fmt.Errorf("invalid file name in marker: %q", fname)
+			}
+			if seen[fname] {
+				return nil, fmt.Errorf("duplicate file name in marker: %q", fname)
+			}
+			seen[fname] = true
+			files = append(files, BundleFile{Name: fname})
+		} else if len(files) == 0 {
+			lead = append(lead, line...)
+		} else {
+			files[len(files)-1].Content = append(files[len(files)-1].Content, line...)
+		}
+	}
+
+	if len(files) == 0 {
+		return []BundleFile{{Name: path.Base(name), Content: lead}}, nil
+	}
+	if len(bytes.TrimSpace(lead)) > 0 {
+		return nil, errors.New("unexpected content before the first file marker")
+	}
+	return files, nil
+}
+
+func (m FileMarker) match(line []byte) (string, bool) {
+	s := strings.TrimRight(string(line), "\r\n")
+	if !strings.HasPrefix(s, m.Prefix) || !strings.HasSuffix(s, m.Suffix) || len(s) < len(m.Prefix)+len(m.Suffix) {
+		return "", false
+	}
+	return strings.TrimSpace(s[len(m.Prefix) : len(s)-len(m.Suffix)]), true
+}
+
+// validBundleName reports whether name is a clean relative path which stays
+// inside the archive root.
+func validBundleName(name string) bool {
+	return len(name) > 0 && !strings.HasPrefix(name, "/") && path.Clean(name) == name &&
+		name != ".." && !strings.HasPrefix(name, "../")
+}
+
+func writeZip(w io.Writer, files []BundleFile) error {
+	zw := zip.NewWriter(w)
+	for _, f := range files {
+		fw, err := zw.Create(f.Name)
+		if err != nil {
+			return err
+		}
+		if _, err = fw.Write(f.Content); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// ZipHandler renders the template and returns the files split by the file
+// marker as a zip archive.
+func (s *Server) ZipHandler(extract func(r *http.Request) (FileRef, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ref, out, ok := s.renderRequest(extract, w, r)
+		if !ok {
+			return
+		}
+		files, err := s.FileMarker.Split(effectivePath(ref.FilePath), out)
+		if s.checkFailure(err, http.StatusUnprocessableEntity, w) {
+			return
+		}
+		var buf bytes.Buffer
+		if s.checkFailure(writeZip(&buf, files), http.StatusInternalServerError, w) {
+			return
+		}
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+			"filename": sanitizeFilename(path.Base(ref.FilePath) + ".zip"),
+		}))
+		w.Write(buf.Bytes())
+	}
+}
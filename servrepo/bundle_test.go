@@ -0,0 +1,84 @@
+package servrepo
+
+import (
+	"archive/zip"
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseFileMarker(t *testing.T) {
+	m, err := ParseFileMarker("---FILE: %s---")
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultFileMarker, m)
+
+	for _, bad := range []string{"", "---FILE---", "%s", "%s-%s"} {
+		_, err = ParseFileMarker(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestFileMarkerSplit(t *testing.T) {
+	m := DefaultFileMarker
+
+	files, err := m.Split("dir/hi.txt", []byte("Hi!\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, []BundleFile{{"hi.txt", []byte("Hi!\n")}}, files)
+
+	files, err = m.Split("x", []byte("\n---FILE: a.txt---\nA\n---FILE:  conf/b.ini ---\r\nB\r\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, []BundleFile{{"a.txt", []byte("A\n")}, {"conf/b.ini", []byte("B\r\n")}}, files)
+
+	files, err = m.Split("x", []byte("---FILE: empty---"))
+	assert.NoError(t, err)
+	assert.Equal(t, []BundleFile{{Name: "empty"}}, files)
+
+	for _, bad := range []string{
+		"oops\n---FILE: a---\n",
+		"---FILE: a---\n---FILE: a---\n",
+		"---FILE: ../a---\n",
+		"---FILE: /etc/a---\n",
+		"---FILE: a/../../b---\n",
+		"---FILE: ---\n",
+	} {
+		_, err = m.Split("x", []byte(bad))
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestZipHandler(t *testing.T) {
+	repo := memRepo{
+		INIT_COMMIT + "::bundle.tmpl": "---FILE: a.txt---\n{{ .who }}\n---FILE: b/c.txt---\nC\n",
+		INIT_COMMIT + "::bad.tmpl":    "oops\n---FILE: a.txt---\n",
+	}
+	r := mux.NewRouter()
+	r.Path("/zip/" + HashPattern + "/" + PathPattern).HandlerFunc(NewServer(repo).ZipHandler(ExtractRefFromMuxVars))
+	s := httptest.NewServer(r)
+	defer s.Close()
+
+	resp, err := http.Get(s.URL + "/zip/" + INIT_COMMIT + "/bundle.tmpl?who=world")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "application/zip", resp.Header.Get("Content-Type"))
+	body, _ := ioutil.ReadAll(resp.Body)
+	zr, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	assert.NoError(t, err)
+	contents := make(map[string]string)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		assert.NoError(t, err)
+		b, _ := ioutil.ReadAll(rc)
+		rc.Close()
+		contents[f.Name] = string(b)
+	}
+	assert.Equal(t, map[string]string{"a.txt": "world\n", "b/c.txt": "C\n"}, contents)
+
+	resp, err = http.Get(s.URL + "/zip/" + INIT_COMMIT + "/bad.tmpl")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+}
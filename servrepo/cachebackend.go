@@ -0,0 +1,86 @@
+package servrepo
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// TemplateCache is a cache backend of CachedTmplRepo which may fail, e.g. one
+// shared by servers over the network. The in-process lru cache never does.
+type TemplateCache interface {
+	Get(key string) (*template.Template, bool, error)
+	Add(key string, tmpl *template.Template) error
+	Remove(key string) error
+}
+
+// ErrCacheFailed is returned by CachedTmplRepo.GetTemplate when its backend
+// fails and FailClosed is set, as distinguished from failures of the
+// underlying repo.
+type ErrCacheFailed struct {
+	Err error
+}
+
+func (e ErrCacheFailed) Error() string {
+	return fmt.Sprintf("template cache is unavailable: %v", e.Err)
+}
+
+// CacheErrorLogInterval is the least interval between two logged errors of a
+// cache backend, the errors in between are only counted.
+var CacheErrorLogInterval = 10 * time.Second
+
+// logLimiter logs at most once per interval. A nil *logLimiter logs always.
+type logLimiter struct {
+	mu         sync.Mutex
+	last       time.Time
+	suppressed int
+}
+
+func (l *logLimiter) Printf(interval time.Duration, format string, v ...interface{}) {
+	if l == nil {
+		log.Printf(format, v...)
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now := time.Now(); now.Sub(l.last) >= interval {
+		if l.suppressed > 0 {
+			format += fmt.Sprintf(" (%d more suppressed)", l.suppressed)
+		}
+		log.Printf(format, v...)
+		l.last, l.suppressed = now, 0
+		return
+	}
+	l.suppressed++
+}
+
+// backendGet looks key up in the Backend, which fails open unless FailClosed
+// is set: an error is logged and counts as a miss.
+func (r *CachedTmplRepo) backendGet(key string) (*template.Template, bool, error) {
+	tmpl, ok, err := r.Backend.Get(key)
+	if err != nil {
+		r.logCacheError("get", key, err)
+		if r.FailClosed {
+			return nil, false, ErrCacheFailed{Err: err}
+		}
+		return nil, false, nil
+	}
+	return tmpl, ok, nil
+}
+
+// backendAdd adds tmpl to the Backend, failing like backendGet.
+func (r *CachedTmplRepo) backendAdd(key string, tmpl *template.Template) error {
+	if err := r.Backend.Add(key, tmpl); err != nil {
+		r.logCacheError("add", key, err)
+		if r.FailClosed {
+			return ErrCacheFailed{Err: err}
+		}
+	}
+	return nil
+}
+
+func (r *CachedTmplRepo) logCacheError(op string, key string, err error) {
+	r.cacheErrors.Printf(CacheErrorLogInterval, "failed to %s %s in template cache: %v", op, key, err)
+}
@@ -0,0 +1,111 @@
+package servrepo
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"text/template"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// brokenCache is a TemplateCache which is always down.
+type brokenCache struct{}
+
+func (brokenCache) Get(string) (*template.Template, bool, error) {
+	return nil, false, errors.New("connection refused")
+}
+func (brokenCache) Add(string, *template.Template) error { return errors.New("connection refused") }
+func (brokenCache) Remove(string) error                  { return errors.New("connection refused") }
+
+// mapCache is a TemplateCache which never fails.
+type mapCache map[string]*template.Template
+
+func (m mapCache) Get(key string) (*template.Template, bool, error) {
+	tmpl, ok := m[key]
+	return tmpl, ok, nil
+}
+func (m mapCache) Add(key string, tmpl *template.Template) error { m[key] = tmpl; return nil }
+func (m mapCache) Remove(key string) error                       { delete(m, key); return nil }
+
+func TestCachedTmplRepoBackendFailure(t *testing.T) {
+	r := repo(t, "..", 32).(*CachedTmplRepo)
+	r.Backend = brokenCache{}
+	ref := FileRef{CommitHash: INIT_COMMIT, FilePath: "templates/hi.txt"}
+
+	tpl, err := r.GetTemplate(ref, false)
+	assert.NoError(t, err)
+	out, err := render(tpl, map[string]interface{}{"who": "world"})
+	assert.NoError(t, err)
+	assert.Equal(t, "Hi, world!\n", string(out))
+
+	r.FailClosed = true
+	_, err = r.GetTemplate(ref, false)
+	assert.IsType(t, ErrCacheFailed{}, err)
+
+	s := server(r)
+	defer s.Close()
+	resp, err := http.Get(s.URL + "/raw/" + INIT_COMMIT + "/templates/hi.txt?who=world")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+}
+
+func TestLogLimiter(t *testing.T) {
+	var l logLimiter
+	l.Printf(time.Hour, "a")
+	l.Printf(time.Hour, "b")
+	l.Printf(time.Hour, "c")
+	assert.Equal(t, 2, l.suppressed)
+	l.Printf(0, "d")
+	assert.Equal(t, 0, l.suppressed)
+}
+
+func TestReadyHandler(t *testing.T) {
+	r := repo(t, "..", 32).(*CachedTmplRepo)
+	get := func(opts ...func(s *Server)) (int, string) {
+		s := server(r, opts...)
+		defer s.Close()
+		resp, err := http.Get(s.URL + "/readyz")
+		assert.NoError(t, err)
+		body, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+	withCheck := func(s *Server) { s.ReadyCache = r }
+
+	status, body := get()
+	assert.Equal(t, http.StatusOK, status)
+	assert.JSONEq(t, `{"ok":true}`, body)
+	status, body = get(withCheck)
+	assert.Equal(t, http.StatusOK, status)
+	assert.JSONEq(t, `{"ok":true,"cache":"ok"}`, body)
+	assert.Equal(t, 0, r.Cache.Len(), "the lru cache isn't touched")
+
+	r.Backend = mapCache{}
+	status, body = get(withCheck)
+	assert.Equal(t, http.StatusOK, status)
+	assert.JSONEq(t, `{"ok":true,"cache":"ok"}`, body)
+
+	r.Backend = brokenCache{}
+	status, body = get(withCheck)
+	assert.Equal(t, http.StatusServiceUnavailable, status)
+	assert.JSONEq(t, `{"ok":false,"cache":"failed","error":"connection refused"}`, body)
+
+	// a passing probe isn't replayed by the response cache
+	r.Backend = mapCache{}
+	srv := NewServer(r)
+	srv.ReadyCache = r
+	cache, err := NewResponseCache(8, time.Minute)
+	assert.NoError(t, err)
+	h := cache.Handler(srv.Handler())
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/readyz", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+	r.Backend = brokenCache{}
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/readyz", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+}
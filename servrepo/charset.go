@@ -0,0 +1,45 @@
+package servrepo
+
+import (
+	"fmt"
+	"mime"
+
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+// errUnsupportedCharset is returned by transcode for an unknown charset.
+type errUnsupportedCharset string
+
+func (e errUnsupportedCharset) Error() string {
+	return fmt.Sprintf("unsupported charset: %q", string(e))
+}
+
+// transcode encodes the UTF-8 out in charset, which is any name or alias
+// known to the WHATWG encoding spec, e.g. "latin1". It returns the canonical
+// name of the charset too.
+func transcode(out []byte, charset string) ([]byte, string, error) {
+	enc, err := htmlindex.Get(charset)
+	if err != nil {
+		return nil, "", errUnsupportedCharset(charset)
+	}
+	name, err := htmlindex.Name(enc)
+	if err != nil {
+		return nil, "", errUnsupportedCharset(charset)
+	}
+	encoded, err := enc.NewEncoder().Bytes(out)
+	if err != nil {
+		return nil, "", fmt.Errorf("output can't be encoded in %s: %v", name, err)
+	}
+	return encoded, name, nil
+}
+
+// withCharset sets the charset param of the media type ctype, which defaults
+// to text/plain.
+func withCharset(ctype, charset string) string {
+	mediatype, params, err := mime.ParseMediaType(ctype)
+	if err != nil {
+		mediatype, params = "text/plain", map[string]string{}
+	}
+	params["charset"] = charset
+	return mime.FormatMediaType(mediatype, params)
+}
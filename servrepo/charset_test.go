@@ -0,0 +1,63 @@
+package servrepo
+
+import (
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTranscode(t *testing.T) {
+	out, name, err := transcode([]byte("café"), "latin1")
+	assert.NoError(t, err)
+	assert.Equal(t, "windows-1252", name)
+	assert.Equal(t, []byte("caf\xe9"), out)
+
+	_, _, err = transcode([]byte("café"), "klingon")
+	assert.Equal(t, errUnsupportedCharset("klingon"), err)
+
+	_, _, err = transcode([]byte("日本"), "latin1")
+	assert.Error(t, err)
+
+	assert.Equal(t, "text/plain; charset=windows-1252", withCharset("text/plain; charset=utf-8", "windows-1252"))
+	assert.Equal(t, "text/plain; charset=shift_jis", withCharset("", "shift_jis"))
+}
+
+func TestRawHandlerCharset(t *testing.T) {
+	s := server(memRepo{INIT_COMMIT + "::a.conf": "{{ .who }}"})
+	defer s.Close()
+	url := s.URL + "/raw/" + INIT_COMMIT + "/a.conf?who=caf%C3%A9"
+
+	resp, err := http.Get(url + "&charset=iso-8859-1")
+	assert.NoError(t, err)
+	assert.Equal(t, "text/plain; charset=windows-1252", resp.Header.Get("Content-Type"))
+	body, _ := ioutil.ReadAll(resp.Body)
+	assert.Equal(t, "caf\xe9", string(body))
+
+	resp, err = http.Get(url + "&charset=klingon")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestRawHandlerBOM(t *testing.T) {
+	s := server(memRepo{INIT_COMMIT + "::a.txt": "{{ .who }}"})
+	defer s.Close()
+	url := s.URL + "/raw/" + INIT_COMMIT + "/a.txt?who=world"
+
+	for q, expect := range map[string]string{
+		"&bom=true":               "\xef\xbb\xbfworld",
+		"&bom=true&charset=utf-8": "\xef\xbb\xbfworld",
+		"&bom=false":              "world",
+		"&bom=true&footer=md5":    "\xef\xbb\xbfworld\n# md5: 7d793037a0760186574b0282f2f435e7\n",
+	} {
+		resp, err := http.Get(url + q)
+		assert.NoError(t, err)
+		body, _ := ioutil.ReadAll(resp.Body)
+		assert.Equal(t, expect, string(body), q)
+	}
+
+	resp, err := http.Get(url + "&bom=true&charset=latin1")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
@@ -0,0 +1,132 @@
+package servrepo
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// ChecksumAlgos are the algorithms /checksums computes unless ?algos= asks
+// for some of them.
+var ChecksumAlgos = []string{"md5", "sha1", "sha256"}
+
+var checksumHashes = map[string]func() hash.Hash{
+	"sha1": sha1.New,
+}
+
+func init() {
+	for algo, newHash := range footerHashes {
+		checksumHashes[algo] = newHash
+	}
+}
+
+// parseAlgos parses a comma separated list of checksum algorithms.
+func parseAlgos(list string) ([]string, error) {
+	algos := strings.Split(list, ",")
+	for _, algo := range algos {
+		if _, ok := checksumHashes[algo]; !ok {
+			return nil, fmt.Errorf("unsupported checksum: %q", algo)
+		}
+	}
+	return algos, nil
+}
+
+// checksums computes the checksums of out with the algos in a single pass.
+func checksums(out []byte, algos []string) map[string]string {
+	hashes := make([]hash.Hash, len(algos))
+	writers := make([]io.Writer, len(algos))
+	for i, algo := range algos {
+		hashes[i] = checksumHashes[algo]()
+		writers[i] = hashes[i]
+	}
+	io.MultiWriter(writers...).Write(out)
+	sums := make(map[string]string, len(algos))
+	for i, algo := range algos {
+		sums[algo] = hex.EncodeToString(hashes[i].Sum(nil))
+	}
+	return sums
+}
+
+// ChecksumsHandler writes the checksums of the rendered template, as a json
+// object of algo to hex digest, or as "algo: digest" lines with ?format=text.
+// ?algos= selects the algorithms by a comma separated list, see ChecksumAlgos.
+// The output is what /md5 sees for the same request.
+func (s *Server) ChecksumsHandler(extract func(r *http.Request) (FileRef, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		algos := ChecksumAlgos
+		if list := r.FormValue("algos"); len(list) > 0 {
+			var err error
+			if algos, err = parseAlgos(list); s.checkFailure(err, http.StatusBadRequest, w) {
+				return
+			}
+		}
+		format := r.FormValue("format")
+		if format != "" && format != "json" && format != "text" {
+			s.checkFailure(fmt.Errorf("unknown format %q, expect json or text", format), http.StatusBadRequest, w)
+			return
+		}
+		ref, out, ok := s.checksumRequest(extract, w, r)
+		if !ok {
+			return
+		}
+		sums := checksums(out, algos)
+		s.setBlobHash(w, ref)
+		if format == "text" {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			var lines strings.Builder
+			for _, algo := range algos {
+				lines.WriteString(algo + ": " + sums[algo] + "\n")
+			}
+			w.Write([]byte(lines.String()))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(sums)
+	}
+}
+
+type assertResult struct {
+	Algo     string `json:"algo"`
+	Expected string `json:"expected"`
+	Actual   string `json:"actual"`
+}
+
+// AssertHandler checks the checksum of the rendered template against
+// ?expect=, with 200 if it matches and 409 otherwise. The checksum is the md5
+// unless ?algo= asks for another one of ChecksumAlgos. Both checksums are
+// written as json either way.
+func (s *Server) AssertHandler(extract func(r *http.Request) (FileRef, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		algo := r.FormValue("algo")
+		if len(algo) == 0 {
+			algo = "md5"
+		}
+		if _, ok := checksumHashes[algo]; !ok {
+			s.checkFailure(fmt.Errorf("unsupported checksum: %q", algo), http.StatusBadRequest, w)
+			return
+		}
+		expect := strings.ToLower(r.FormValue("expect"))
+		if len(expect) == 0 {
+			s.checkFailure(errors.New("expect is missing"), http.StatusBadRequest, w)
+			return
+		}
+		ref, out, ok := s.checksumRequest(extract, w, r)
+		if !ok {
+			return
+		}
+
+		res := assertResult{Algo: algo, Expected: expect, Actual: checksums(out, []string{algo})[algo]}
+		s.setBlobHash(w, ref)
+		w.Header().Set("Content-Type", "application/json")
+		if res.Actual != res.Expected {
+			w.WriteHeader(http.StatusConflict)
+		}
+		json.NewEncoder(w).Encode(res)
+	}
+}
@@ -0,0 +1,64 @@
+package servrepo
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChecksumsHandler(t *testing.T) {
+	s := server(repo(t, "..", 32))
+	defer s.Close()
+	get := func(q string) (int, string) {
+		resp, err := http.Get(s.URL + "/checksums/" + INIT_COMMIT + "/templates/hi.txt?who=world" + q)
+		assert.NoError(t, err)
+		body, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	status, body := get("")
+	assert.Equal(t, http.StatusOK, status)
+	var sums map[string]string
+	assert.NoError(t, json.Unmarshal([]byte(body), &sums))
+	assert.Equal(t, map[string]string{
+		"md5":    "07197f7673c0074a7e0a64839ba45dd5",
+		"sha1":   "107c70ebe122b36bf6132fb6e8078977467c0085",
+		"sha256": "2e1ccd6d22764bbbcaed41402eaf4b36bc6b9c66bb33680205e5b32c6b6c344e",
+	}, sums)
+
+	status, body = get("&algos=sha256,md5&format=text")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "sha256: "+sums["sha256"]+"\nmd5: "+sums["md5"]+"\n", body)
+
+	status, _ = get("&algos=crc32")
+	assert.Equal(t, http.StatusBadRequest, status)
+	status, _ = get("&format=xml")
+	assert.Equal(t, http.StatusBadRequest, status)
+}
+
+func TestAssertHandler(t *testing.T) {
+	s := server(repo(t, "..", 32))
+	defer s.Close()
+	get := func(q string) (int, assertResult) {
+		resp, err := http.Get(s.URL + "/assert/" + INIT_COMMIT + "/templates/hi.txt?who=world" + q)
+		assert.NoError(t, err)
+		var res assertResult
+		json.NewDecoder(resp.Body).Decode(&res)
+		return resp.StatusCode, res
+	}
+
+	status, res := get("&expect=07197F7673C0074A7E0A64839BA45DD5")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, assertResult{"md5", "07197f7673c0074a7e0a64839ba45dd5", "07197f7673c0074a7e0a64839ba45dd5"}, res)
+	status, res = get("&expect=07197f7673c0074a7e0a64839ba45dd5&algo=sha256")
+	assert.Equal(t, http.StatusConflict, status)
+	assert.Equal(t, "2e1ccd6d22764bbbcaed41402eaf4b36bc6b9c66bb33680205e5b32c6b6c344e", res.Actual)
+
+	status, _ = get("")
+	assert.Equal(t, http.StatusBadRequest, status)
+	status, _ = get("&expect=x&algo=crc32")
+	assert.Equal(t, http.StatusBadRequest, status)
+}
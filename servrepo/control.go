@@ -0,0 +1,137 @@
+package servrepo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"text/template"
+)
+
+// ControlStatuses are the statuses a template may set by {{ status }}.
+var ControlStatuses = []int{
+	http.StatusOK,
+	http.StatusCreated,
+	http.StatusAccepted,
+	http.StatusMovedPermanently,
+	http.StatusFound,
+	http.StatusSeeOther,
+	http.StatusTemporaryRedirect,
+	http.StatusPermanentRedirect,
+	http.StatusNotFound,
+	http.StatusGone,
+}
+
+// renderControl records the status and the redirect a template asks for, the
+// handler applies them after the render. A nil renderControl validates the
+// values but records nothing, for the routes which don't apply them.
+type renderControl struct {
+	status   int
+	location string
+}
+
+type renderControlKey struct{}
+
+// withRenderControl returns r carrying a renderControl for its render.
+func withRenderControl(r *http.Request) (*http.Request, *renderControl) {
+	ctl := &renderControl{}
+	return r.WithContext(context.WithValue(r.Context(), renderControlKey{}, ctl)), ctl
+}
+
+func renderControlOf(ctx context.Context) *renderControl {
+	ctl, _ := ctx.Value(renderControlKey{}).(*renderControl)
+	return ctl
+}
+
+func init() {
+	for name, fn := range controlFuncs(nil) {
+		funcs[name] = fn
+	}
+}
+
+// controlFuncs returns the functions of templates setting the response
+// status, they output nothing.
+func controlFuncs(ctl *renderControl) template.FuncMap {
+	return template.FuncMap{
+		// status sets the status of the response, e.g. {{ status 404 }}, it
+		// has to be one of ControlStatuses.
+		"status": func(code int) (string, error) {
+			if !allowedStatus(code) {
+				return "", fmt.Errorf("status %d is not supported", code)
+			}
+			if ctl != nil {
+				ctl.status = code
+			}
+			return "", nil
+		},
+		// redirect redirects to the url with a 302, or with the redirect
+		// status set by {{ status }}, e.g. {{ redirect "/x" }}. The url is a
+		// path or an absolute http(s) url.
+		"redirect": func(target string) (string, error) {
+			if err := checkRedirect(target); err != nil {
+				return "", err
+			}
+			if ctl != nil {
+				ctl.location = target
+			}
+			return "", nil
+		},
+	}
+}
+
+func allowedStatus(code int) bool {
+	for _, s := range ControlStatuses {
+		if s == code {
+			return true
+		}
+	}
+	return false
+}
+
+func checkRedirect(target string) error {
+	if strings.ContainsAny(target, "\r\n") {
+		return errors.New("redirect url must not contain line breaks")
+	}
+	if strings.HasPrefix(target, "/") && !strings.HasPrefix(target, "//") {
+		return nil
+	}
+	u, err := url.Parse(target)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+		return fmt.Errorf("invalid redirect url %q, expect a path or an http(s) url", target)
+	}
+	return nil
+}
+
+// set tells whether the template asked for a status or a redirect.
+func (ctl *renderControl) set() bool {
+	return ctl != nil && (ctl.status != 0 || len(ctl.location) > 0)
+}
+
+// check tells whether the status and the redirect asked for go together.
+func (ctl *renderControl) check() error {
+	redirect := ctl.status >= 300 && ctl.status <= 399
+	switch {
+	case len(ctl.location) > 0 && ctl.status != 0 && !redirect:
+		return fmt.Errorf("status %d can't redirect", ctl.status)
+	case len(ctl.location) == 0 && redirect:
+		return fmt.Errorf("status %d needs a redirect url", ctl.status)
+	}
+	return nil
+}
+
+// apply sets the status and the Location header asked for, it has to be
+// called before the body is written.
+func (ctl *renderControl) apply(w http.ResponseWriter) {
+	status := ctl.status
+	if len(ctl.location) > 0 {
+		w.Header().Set("Location", ctl.location)
+		if status == 0 {
+			status = http.StatusFound
+		}
+	}
+	if status != 0 {
+		w.WriteHeader(status)
+	}
+}
@@ -0,0 +1,48 @@
+package servrepo
+
+import (
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRenderControl(t *testing.T) {
+	s := server(memRepo{
+		INIT_COMMIT + "::gone.txt":  `{{ status 410 }}gone`,
+		INIT_COMMIT + "::moved.txt": `{{ status 301 }}{{ include "to.txt" }}`,
+		INIT_COMMIT + "::to.txt":    `{{ redirect "/raw/x" }}`,
+		INIT_COMMIT + "::away.txt":  `{{ redirect "https://example.com/" }}`,
+		INIT_COMMIT + "::bad.txt":   `{{ status 500 }}`,
+		INIT_COMMIT + "::lost.txt":  `{{ status 302 }}`,
+		INIT_COMMIT + "::evil.txt":  `{{ redirect "//evil.com" }}`,
+	})
+	defer s.Close()
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	get := func(path string) (int, string, string) {
+		resp, err := client.Get(s.URL + "/raw/" + INIT_COMMIT + "/" + path)
+		assert.NoError(t, err)
+		body, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, resp.Header.Get("Location"), string(body)
+	}
+
+	status, _, body := get("gone.txt")
+	assert.Equal(t, http.StatusGone, status)
+	assert.Equal(t, "gone", body)
+	status, location, _ := get("moved.txt")
+	assert.Equal(t, http.StatusMovedPermanently, status)
+	assert.Equal(t, "/raw/x", location)
+	status, location, _ = get("away.txt")
+	assert.Equal(t, http.StatusFound, status)
+	assert.Equal(t, "https://example.com/", location)
+	for _, path := range []string{"bad.txt", "lost.txt", "evil.txt"} {
+		status, _, _ = get(path)
+		assert.Equal(t, http.StatusInternalServerError, status, path)
+	}
+
+	// routes other than /raw ignore them
+	resp, err := http.Get(s.URL + "/md5/" + INIT_COMMIT + "/gone.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
@@ -0,0 +1,87 @@
+package servrepo
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrOriginNotAllowed is the 403 error of preflight requests from an origin
+// not allowed by CORS.
+var ErrOriginNotAllowed = errors.New("origin is not allowed")
+
+// CORSExposeHeaders are the response headers browsers let cross-origin
+// clients read, besides the simple ones like Content-Type.
+var CORSExposeHeaders = []string{"ETag", "Server-Timing", "Warning", "X-Blob-Hash", "X-Cache"}
+
+// CORS lets browsers call the server from the Origins, with the Methods. It
+// answers preflight requests and adds the Access-Control-* headers to the
+// responses to allowed origins, other requests pass through untouched.
+type CORS struct {
+	// Origins lists the allowed origins, e.g. https://example.com, "*"
+	// allows any.
+	Origins []string
+	Methods []string
+	// MaxAge tells how long browsers may cache a preflight response.
+	MaxAge time.Duration
+}
+
+// NewCORS returns a CORS allowing the origins with the methods, GET and
+// HEAD if none is given.
+func NewCORS(origins, methods []string) *CORS {
+	if len(methods) == 0 {
+		methods = []string{"GET", "HEAD"}
+	}
+	return &CORS{Origins: origins, Methods: methods, MaxAge: 10 * time.Minute}
+}
+
+func (c *CORS) allowOrigin(origin string) (string, bool) {
+	for _, o := range c.Origins {
+		if o == "*" {
+			return "*", true
+		}
+		if strings.EqualFold(o, origin) {
+			return origin, true
+		}
+	}
+	return "", false
+}
+
+// Handler handles preflight requests and adds the CORS headers to the
+// responses of handler.
+func (c *CORS) Handler(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if len(origin) == 0 {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		preflight := r.Method == http.MethodOptions && len(r.Header.Get("Access-Control-Request-Method")) > 0
+		w.Header().Add("Vary", "Origin")
+		allowed, ok := c.allowOrigin(origin)
+		if !ok {
+			if preflight {
+				checkFailure(ErrOriginNotAllowed, http.StatusForbidden, w)
+				return
+			}
+			handler.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Access-Control-Allow-Origin", allowed)
+		if !preflight {
+			w.Header().Set("Access-Control-Expose-Headers", strings.Join(CORSExposeHeaders, ", "))
+			handler.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Access-Control-Allow-Methods", strings.Join(c.Methods, ", "))
+		if headers := r.Header.Get("Access-Control-Request-Headers"); len(headers) > 0 {
+			w.Header().Set("Access-Control-Allow-Headers", headers)
+		}
+		if c.MaxAge > 0 {
+			w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(c.MaxAge/time.Second)))
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
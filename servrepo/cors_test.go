@@ -0,0 +1,60 @@
+package servrepo
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCORS(t *testing.T) {
+	served := 0
+	h := NewCORS([]string{"https://a.example"}, nil).Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		served++
+	}))
+	do := func(method, origin string, header map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/raw/x", nil)
+		if len(origin) > 0 {
+			req.Header.Set("Origin", origin)
+		}
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+	preflight := map[string]string{"Access-Control-Request-Method": "GET", "Access-Control-Request-Headers": "X-Tmpl-Who"}
+
+	w := do("GET", "", nil)
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, 1, served)
+
+	w = do("GET", "https://a.example", nil)
+	assert.Equal(t, "https://a.example", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Contains(t, w.Header().Get("Access-Control-Expose-Headers"), "X-Blob-Hash")
+	assert.Equal(t, "Origin", w.Header().Get("Vary"))
+	assert.Equal(t, 2, served)
+
+	w = do("GET", "https://b.example", nil)
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, 3, served)
+
+	w = do("OPTIONS", "https://a.example", preflight)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "https://a.example", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "GET, HEAD", w.Header().Get("Access-Control-Allow-Methods"))
+	assert.Equal(t, "X-Tmpl-Who", w.Header().Get("Access-Control-Allow-Headers"))
+	assert.Equal(t, "600", w.Header().Get("Access-Control-Max-Age"))
+	assert.Equal(t, 3, served)
+
+	w = do("OPTIONS", "https://b.example", preflight)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, 3, served)
+
+	h = NewCORS([]string{"*"}, []string{"GET", "POST"}).Handler(http.NotFoundHandler())
+	w = do("OPTIONS", "https://b.example", preflight)
+	assert.Equal(t, "*", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "GET, POST", w.Header().Get("Access-Control-Allow-Methods"))
+}
@@ -0,0 +1,49 @@
+package servrepo
+
+import (
+	"embed"
+	"io/fs"
+	"text/template"
+)
+
+//go:embed demo
+var demoFS embed.FS
+
+// EmbeddedTmplRepo serves the templates of a file system built into the
+// binary, e.g. for demos, so no git repo is needed. Files don't change with
+// commits, the same files are served at any commit hash.
+type EmbeddedTmplRepo struct {
+	FS fs.FS
+	// MissingKey is like GitTmplRepo.MissingKey.
+	MissingKey string
+}
+
+// NewDemoTmplRepo returns a repo of the built-in demo templates, hi.txt and
+// page.html.
+func NewDemoTmplRepo() *EmbeddedTmplRepo {
+	sub, _ := fs.Sub(demoFS, "demo")
+	return &EmbeddedTmplRepo{FS: sub}
+}
+
+func (r *EmbeddedTmplRepo) GetTemplate(ref FileRef, sync bool) (*template.Template, error) {
+	raw, err := r.ReadSource(ref)
+	if err != nil {
+		return nil, err
+	}
+	return parseTemplate(ref, raw, r.MissingKey)
+}
+
+// Sync has nothing to do, the files are built in.
+func (r *EmbeddedTmplRepo) Sync() error { return nil }
+
+// ReadSource reads the source of the file, a gzipped source is decompressed.
+func (r *EmbeddedTmplRepo) ReadSource(ref FileRef) ([]byte, error) {
+	if !fs.ValidPath(ref.FilePath) {
+		return nil, ErrFileNotFound
+	}
+	raw, err := fs.ReadFile(r.FS, ref.FilePath)
+	if err != nil {
+		return nil, ErrFileNotFound
+	}
+	return decodeSource(ref.FilePath, raw)
+}
@@ -0,0 +1,44 @@
+package servrepo
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEmbeddedTmplRepo(t *testing.T) {
+	r := &EmbeddedTmplRepo{FS: fstest.MapFS{
+		"a/b.txt": {Data: []byte("{{ .x }}")},
+		"bad.txt": {Data: []byte("{{ x")},
+	}}
+	_, err := r.GetTemplate(FileRef{CommitHash: INIT_COMMIT, FilePath: "a/b.txt"}, false)
+	assert.NoError(t, err)
+	for _, p := range []string{"nope.txt", "a", "../a/b.txt", "/a/b.txt"} {
+		_, err = r.GetTemplate(FileRef{CommitHash: INIT_COMMIT, FilePath: p}, false)
+		assert.Equal(t, ErrFileNotFound, err, p)
+	}
+	_, err = r.GetTemplate(FileRef{CommitHash: INIT_COMMIT, FilePath: "bad.txt"}, false)
+	assert.IsType(t, ErrParseFailed{}, err)
+}
+
+func TestDemoTmplRepo(t *testing.T) {
+	demo := NewDemoTmplRepo()
+	s := server(demo, func(s *Server) { s.Sources = demo })
+	defer s.Close()
+	commit := strings.Repeat("0", 40)
+
+	resp, err := http.Get(s.URL + "/raw/" + commit + "/hi.txt?who=world")
+	assert.NoError(t, err)
+	body, _ := ioutil.ReadAll(resp.Body)
+	assert.Equal(t, "Hi, world!\n", string(body))
+
+	resp, err = http.Get(s.URL + "/raw/" + INIT_COMMIT + "/page.html?who=world")
+	assert.NoError(t, err)
+	body, _ = ioutil.ReadAll(resp.Body)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, string(body), "<p>Hi, world!\n</p>")
+}
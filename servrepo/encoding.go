@@ -0,0 +1,25 @@
+package servrepo
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+)
+
+// encodeOutput compresses out with the content coding asked for by
+// ?encoding=, only gzip is supported. Checksums, i.e. ?footer= and /md5, are
+// computed over out before it is compressed.
+func encodeOutput(out []byte, encoding string) ([]byte, error) {
+	if encoding != "gzip" {
+		return nil, fmt.Errorf("unknown encoding %q, expect gzip", encoding)
+	}
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(out); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
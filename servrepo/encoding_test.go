@@ -0,0 +1,32 @@
+package servrepo
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRawHandlerEncoding(t *testing.T) {
+	s := server(repo(t, "..", 32))
+	defer s.Close()
+	// keep the client from asking for and decoding gzip by itself
+	client := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+
+	url := s.URL + "/raw/" + INIT_COMMIT + "/templates/hi.txt?who=world"
+	resp, err := client.Get(url + "&encoding=gzip&footer=md5")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
+	zr, err := gzip.NewReader(resp.Body)
+	assert.NoError(t, err)
+	body, err := ioutil.ReadAll(zr)
+	assert.NoError(t, err)
+	assert.Equal(t, "Hi, world!\n# md5: 07197f7673c0074a7e0a64839ba45dd5\n", string(body))
+
+	resp, err = client.Get(url + "&encoding=br")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
@@ -0,0 +1,20 @@
+package servrepo
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// normalizeEOL converts the line endings of out to eol, which is "lf" or
+// "crlf". A lone carriage return isn't a line ending and is left as is.
+func normalizeEOL(out []byte, eol string) ([]byte, error) {
+	switch eol {
+	case "lf":
+		return bytes.Replace(out, []byte("\r\n"), []byte("\n"), -1), nil
+	case "crlf":
+		out = bytes.Replace(out, []byte("\r\n"), []byte("\n"), -1)
+		return bytes.Replace(out, []byte("\n"), []byte("\r\n"), -1), nil
+	default:
+		return nil, fmt.Errorf("unknown line ending %q, expect lf or crlf", eol)
+	}
+}
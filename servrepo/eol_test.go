@@ -0,0 +1,47 @@
+package servrepo
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeEOL(t *testing.T) {
+	out, err := normalizeEOL([]byte("a\r\nb\nc\rd"), "crlf")
+	assert.NoError(t, err)
+	assert.Equal(t, "a\r\nb\r\nc\rd", string(out))
+
+	out, err = normalizeEOL([]byte("a\r\nb\nc\rd"), "lf")
+	assert.NoError(t, err)
+	assert.Equal(t, "a\nb\nc\rd", string(out))
+
+	_, err = normalizeEOL([]byte("a"), "cr")
+	assert.Error(t, err)
+}
+
+func TestRawHandlerEOL(t *testing.T) {
+	s := server(memRepo{INIT_COMMIT + "::a.txt": "a\r\nb\n"})
+	defer s.Close()
+
+	for q, expect := range map[string]string{
+		"/raw/%s/a.txt?eol=crlf":            "a\r\nb\r\n",
+		"/raw/%s/a.txt?eol=lf":              "a\nb\n",
+		"/raw/%s/a.txt?eol=crlf&footer=md5": "a\r\nb\r\n# md5: 59b0d7772f0561efb95518f3cb8abc60\r\n",
+		"/md5/%s/a.txt?eol=crlf":            "59b0d7772f0561efb95518f3cb8abc60  a.txt\n",
+		"/md5/%s/a.txt?eol=lf":              "dd8c6a395b5dd36c56d23275028f526c  a.txt\n",
+	} {
+		resp, err := http.Get(s.URL + fmt.Sprintf(q, INIT_COMMIT))
+		assert.NoError(t, err)
+		body, _ := ioutil.ReadAll(resp.Body)
+		assert.Equal(t, expect, string(body), q)
+	}
+
+	for _, q := range []string{"/raw/%s/a.txt?eol=cr", "/md5/%s/a.txt?eol=cr"} {
+		resp, err := http.Get(s.URL + fmt.Sprintf(q, INIT_COMMIT))
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode, q)
+	}
+}
@@ -0,0 +1,93 @@
+package servrepo
+
+import (
+	"bytes"
+	htmltemplate "html/template"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// ErrorContext is the data passed to an error page template.
+type ErrorContext struct {
+	Status     int
+	StatusText string
+	Error      string
+}
+
+type errorPage struct {
+	tpl interface {
+		Execute(w io.Writer, data interface{}) error
+	}
+	contentType string
+}
+
+// ErrorPages holds the templates used to render error responses, keyed by
+// http status code.
+type ErrorPages map[int]errorPage
+
+// LoadErrorPages loads error templates from dir, each file is named after the
+// status code it serves (e.g. 404.html). Files with an .html/.htm extension
+// are parsed as html templates, others as text templates.
+func LoadErrorPages(dir string) (ErrorPages, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	pages := make(ErrorPages)
+	for _, info := range infos {
+		if info.IsDir() {
+			continue
+		}
+		name := info.Name()
+		ext := path.Ext(name)
+		status, err := strconv.Atoi(strings.TrimSuffix(name, ext))
+		if err != nil || status < 400 || status > 599 {
+			continue
+		}
+		raw, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		var page errorPage
+		if ext == ".html" || ext == ".htm" {
+			page.tpl, err = htmltemplate.New(name).Parse(string(raw))
+			page.contentType = "text/html; charset=utf-8"
+		} else {
+			page.tpl, err = template.New(name).Parse(string(raw))
+			page.contentType = "text/plain; charset=utf-8"
+		}
+		if err != nil {
+			return nil, err
+		}
+		pages[status] = page
+	}
+	return pages, nil
+}
+
+// Render writes the error page for status, it reports false if there is no
+// such page or the page itself fails to render, in which case nothing has
+// been written and the caller should fall back to plain text.
+func (p ErrorPages) Render(w http.ResponseWriter, err error, status int) bool {
+	page, ok := p[status]
+	if !ok {
+		return false
+	}
+	var buf bytes.Buffer
+	ctx := ErrorContext{Status: status, StatusText: http.StatusText(status), Error: err.Error()}
+	if e := page.tpl.Execute(&buf, ctx); e != nil {
+		log.Print("failed to render error page: " + e.Error())
+		return false
+	}
+	w.Header().Set("Content-Type", page.contentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	w.Write(buf.Bytes())
+	return true
+}
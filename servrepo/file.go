@@ -0,0 +1,38 @@
+package servrepo
+
+import (
+	"bytes"
+	"net/http"
+	"path"
+	"time"
+)
+
+// RawFileReader reads a file as stored in a commit, see GitTmplRepo.ReadFile.
+type RawFileReader interface {
+	ReadFile(ref FileRef) ([]byte, time.Time, error)
+}
+
+// FileHandler serves the file as is, without rendering it. Range and
+// conditional requests are supported, with the commit time as Last-Modified.
+func (s *Server) FileHandler(files RawFileReader, extract func(r *http.Request) (FileRef, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ref, err := extract(r)
+		if s.checkFailure(err, http.StatusBadRequest, w) {
+			return
+		}
+		raw, modtime, err := files.ReadFile(ref)
+		switch err {
+		case nil:
+		case ErrCommitNotFound, ErrFileNotFound:
+			s.checkFailure(err, http.StatusNotFound, w)
+			return
+		case ErrFileForbidden:
+			s.checkFailure(err, http.StatusForbidden, w)
+			return
+		default:
+			s.checkFailure(err, http.StatusInternalServerError, w)
+			return
+		}
+		http.ServeContent(w, r, path.Base(ref.FilePath), modtime, bytes.NewReader(raw))
+	}
+}
@@ -0,0 +1,38 @@
+package servrepo
+
+import (
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileHandler(t *testing.T) {
+	gitRepo := repo(t, "..", 0).(*GitTmplRepo)
+	s := server(gitRepo, func(s *Server) { s.RawFiles = gitRepo })
+	defer s.Close()
+	url := s.URL + "/file/" + INIT_COMMIT + "/templates/hi.txt"
+	src, err := ioutil.ReadFile("../templates/hi.txt")
+	assert.NoError(t, err)
+
+	resp, err := http.Get(url)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/plain; charset=utf-8", resp.Header.Get("Content-Type"))
+	assert.NotEmpty(t, resp.Header.Get("Last-Modified"))
+	body, _ := ioutil.ReadAll(resp.Body)
+	assert.Equal(t, string(src), string(body))
+
+	req, _ := http.NewRequest("GET", url, nil)
+	req.Header.Set("Range", "bytes=4-")
+	resp, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusPartialContent, resp.StatusCode)
+	body, _ = ioutil.ReadAll(resp.Body)
+	assert.Equal(t, string(src[4:]), string(body))
+
+	resp, err = http.Get(s.URL + "/file/" + INIT_COMMIT + "/templates/nope.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
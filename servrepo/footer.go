@@ -0,0 +1,59 @@
+package servrepo
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"path"
+	"strings"
+)
+
+type commentSyntax struct {
+	Prefix string
+	Suffix string
+}
+
+// commentSyntaxes maps file extensions to their line comment syntax.
+var commentSyntaxes = map[string]commentSyntax{
+	".go":   {"// ", ""},
+	".js":   {"// ", ""},
+	".java": {"// ", ""},
+	".c":    {"// ", ""},
+	".h":    {"// ", ""},
+	".sql":  {"-- ", ""},
+	".lua":  {"-- ", ""},
+	".ini":  {"; ", ""},
+	".html": {"<!-- ", " -->"},
+	".xml":  {"<!-- ", " -->"},
+}
+
+var footerHashes = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha256": sha256.New,
+}
+
+// appendFooter appends a comment line carrying the checksum of out, e.g.
+// "# md5: 07197f7673c0074a7e0a64839ba45dd5". The checksum is computed over
+// out as rendered, so it equals what /md5 reports for the same request; a
+// newline is put before the footer if out doesn't end with one. The comment
+// syntax is told from the file extension, or else comment is the prefix.
+func appendFooter(out []byte, algo string, filePath string, comment string) ([]byte, error) {
+	newHash, ok := footerHashes[algo]
+	if !ok {
+		return nil, fmt.Errorf("unsupported footer checksum: %q", algo)
+	}
+	h := newHash()
+	h.Write(out)
+
+	syntax, ok := commentSyntaxes[strings.ToLower(path.Ext(filePath))]
+	if !ok {
+		syntax = commentSyntax{Prefix: comment}
+	}
+	if len(out) > 0 && out[len(out)-1] != '\n' {
+		out = append(out, '\n')
+	}
+	footer := syntax.Prefix + algo + ": " + hex.EncodeToString(h.Sum(nil)) + syntax.Suffix + "\n"
+	return append(out, footer...), nil
+}
@@ -0,0 +1,46 @@
+package servrepo
+
+import (
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAppendFooter(t *testing.T) {
+	out, err := appendFooter([]byte("Hi, world!\n"), "md5", "templates/hi.txt", "# ")
+	assert.NoError(t, err)
+	assert.Equal(t, "Hi, world!\n# md5: 07197f7673c0074a7e0a64839ba45dd5\n", string(out))
+
+	out, err = appendFooter([]byte("Hi, world!\n"), "md5", "index.HTML", "# ")
+	assert.NoError(t, err)
+	assert.Equal(t, "Hi, world!\n<!-- md5: 07197f7673c0074a7e0a64839ba45dd5 -->\n", string(out))
+
+	// the checksum is of the output as rendered, without the added newline
+	out, err = appendFooter([]byte("x"), "sha256", "a.sql", "# ")
+	assert.NoError(t, err)
+	assert.Equal(t, "x\n-- sha256: 2d711642b726b04401627ca9fbac32f5c8530fb1903cc4db02258717921a4881\n", string(out))
+
+	out, err = appendFooter(nil, "md5", "a.unknown", "// ")
+	assert.NoError(t, err)
+	assert.Equal(t, "// md5: d41d8cd98f00b204e9800998ecf8427e\n", string(out))
+
+	_, err = appendFooter(nil, "crc32", "a.txt", "# ")
+	assert.Error(t, err)
+}
+
+func TestRawHandlerFooter(t *testing.T) {
+	s := server(repo(t, "..", 32))
+	defer s.Close()
+
+	url := s.URL + "/raw/" + INIT_COMMIT + "/templates/hi.txt?who=world"
+	resp, err := http.Get(url + "&footer=md5")
+	assert.NoError(t, err)
+	body, _ := ioutil.ReadAll(resp.Body)
+	assert.Equal(t, "Hi, world!\n# md5: 07197f7673c0074a7e0a64839ba45dd5\n", string(body))
+
+	resp, err = http.Get(url + "&footer=nope")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
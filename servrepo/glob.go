@@ -0,0 +1,41 @@
+package servrepo
+
+import (
+	"path"
+	"strings"
+)
+
+// matchGlob reports whether the slash separated name matches the pattern.
+// Segments are matched by path.Match, besides a "**" segment matches any
+// number of segments, e.g. "**/secrets/**" matches "a/secrets/b.txt". A
+// pattern without a slash matches the base name at any depth, so "*.key"
+// matches "certs/server.key". Malformed patterns match nothing.
+func matchGlob(pattern, name string) bool {
+	name = strings.Trim(name, "/")
+	if !strings.Contains(pattern, "/") {
+		ok, _ := path.Match(pattern, path.Base(name))
+		return ok
+	}
+	return matchSegments(strings.Split(strings.Trim(pattern, "/"), "/"), strings.Split(name, "/"))
+}
+
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
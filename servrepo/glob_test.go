@@ -0,0 +1,36 @@
+package servrepo
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchGlob(t *testing.T) {
+	for _, c := range []struct {
+		pattern, name string
+		match         bool
+	}{
+		{"*.key", "server.key", true},
+		{"*.key", "certs/server.key", true},
+		{"*.key", "server.key.txt", false},
+		{"**/secrets/**", "secrets/a.txt", true},
+		{"**/secrets/**", "a/b/secrets/c/d.txt", true},
+		{"**/secrets/**", "a/secrets.txt", false},
+		{"conf/*.yml", "conf/app.yml", true},
+		{"conf/*.yml", "x/conf/app.yml", false},
+		{"conf/**", "conf", true},
+		{"[", "[", false},
+	} {
+		assert.Equal(t, c.match, matchGlob(c.pattern, c.name), c.pattern+" "+c.name)
+	}
+}
+
+func TestDenyBeforeGitAccess(t *testing.T) {
+	// a nil repository panics if it's ever looked at
+	r := &GitTmplRepo{Deny: []string{"**/secrets/**"}}
+	_, err := r.GetTemplate(FileRef{CommitHash: INIT_COMMIT, FilePath: "a/secrets/token.txt"}, true)
+	assert.Equal(t, ErrFileForbidden, err)
+	_, _, err = r.ReadFile(FileRef{CommitHash: INIT_COMMIT, FilePath: "secrets/token.txt"})
+	assert.Equal(t, ErrFileForbidden, err)
+}
@@ -0,0 +1,139 @@
+package servrepo
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"srcd.works/go-git.v4/plumbing"
+	"srcd.works/go-git.v4/plumbing/object"
+)
+
+// MaxHistory caps the number of commits /history lists.
+const MaxHistory = 100
+
+// HistoryEntry is a commit which touched a file.
+type HistoryEntry struct {
+	Hash    string    `json:"hash"`
+	Author  string    `json:"author"`
+	Date    time.Time `json:"date"`
+	Message string    `json:"message"`
+}
+
+// HistoryReader lists the commits which touched a file, see
+// GitTmplRepo.History.
+type HistoryReader interface {
+	History(ref FileRef, limit int) ([]HistoryEntry, error)
+}
+
+// History lists up to limit commits which touched the file of ref, from the
+// commit of ref back, the most recent first. Like git log, a merge is only
+// listed if the file differs from all its parents, otherwise the history is
+// followed through a parent with the same file.
+func (r *GitTmplRepo) History(ref FileRef, limit int) ([]HistoryEntry, error) {
+	if !r.allowed(ref.FilePath) {
+		return nil, ErrFileForbidden
+	}
+	start, err := r.Commit(plumbing.NewHash(ref.CommitHash))
+	if err != nil {
+		return nil, ErrCommitNotFound
+	}
+	blobs := make(map[plumbing.Hash]plumbing.Hash)
+	blobOf := func(c *object.Commit) plumbing.Hash {
+		blob, ok := blobs[c.Hash]
+		if !ok {
+			if file, err := c.File(ref.FilePath); err == nil {
+				blob = file.Hash
+			}
+			blobs[c.Hash] = blob
+		}
+		return blob
+	}
+	if blobOf(start).IsZero() {
+		return nil, ErrFileNotFound
+	}
+
+	entries := []HistoryEntry{}
+	queue := []*object.Commit{start}
+	seen := map[plumbing.Hash]bool{start.Hash: true}
+	for len(queue) > 0 && len(entries) < limit {
+		// walk the most recent commit first
+		next := 0
+		for i, c := range queue {
+			if c.Committer.When.After(queue[next].Committer.When) {
+				next = i
+			}
+		}
+		c := queue[next]
+		queue = append(queue[:next], queue[next+1:]...)
+
+		var parents []*object.Commit
+		if err := c.Parents().ForEach(func(p *object.Commit) error {
+			parents = append(parents, p)
+			return nil
+		}); err != nil {
+			return nil, err
+		}
+		blob, touched, follow := blobOf(c), true, parents
+		for _, p := range parents {
+			if blobOf(p) == blob {
+				touched, follow = false, []*object.Commit{p}
+				break
+			}
+		}
+		if touched && !(len(parents) == 0 && blob.IsZero()) {
+			entries = append(entries, HistoryEntry{
+				Hash:    c.Hash.String(),
+				Author:  fmt.Sprintf("%s <%s>", c.Author.Name, c.Author.Email),
+				Date:    c.Author.When,
+				Message: c.Message,
+			})
+		}
+		for _, p := range follow {
+			if !seen[p.Hash] {
+				seen[p.Hash] = true
+				queue = append(queue, p)
+			}
+		}
+	}
+	return entries, nil
+}
+
+// HistoryHandler lists the commits which touched the file as json, at most
+// MaxHistory or ?limit= of them.
+func (s *Server) HistoryHandler(history HistoryReader, extract func(r *http.Request) (FileRef, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ref, err := extract(r)
+		if s.checkFailure(err, http.StatusBadRequest, w) {
+			return
+		}
+		limit := MaxHistory
+		if v := r.FormValue("limit"); len(v) > 0 {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				s.checkFailure(fmt.Errorf("invalid limit %q", v), http.StatusBadRequest, w)
+				return
+			}
+			if n < limit {
+				limit = n
+			}
+		}
+		entries, err := history.History(ref, limit)
+		switch err {
+		case nil:
+		case ErrCommitNotFound, ErrFileNotFound:
+			s.checkFailure(err, http.StatusNotFound, w)
+			return
+		case ErrFileForbidden:
+			s.checkFailure(err, http.StatusForbidden, w)
+			return
+		default:
+			s.checkFailure(err, http.StatusInternalServerError, w)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(entries)
+	}
+}
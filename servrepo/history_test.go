@@ -0,0 +1,82 @@
+package servrepo
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"srcd.works/go-git.v4"
+)
+
+func TestHistory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "history")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	day := 0
+	gitCmd := func(args ...string) string {
+		// one commit a day, so the walk order is well defined
+		date := fmt.Sprintf("2024-01-%02dT00:00:00Z", day)
+		return runGitEnv(t, dir, []string{"GIT_AUTHOR_DATE=" + date, "GIT_COMMITTER_DATE=" + date}, args...)
+	}
+	commit := func(file, content, msg string) string {
+		day++
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, file), []byte(content), 0644))
+		gitCmd("add", file)
+		gitCmd("commit", "-q", "-m", msg)
+		return gitCmd("rev-parse", "HEAD")
+	}
+	gitCmd("init", "-q", "-b", "main")
+	c1 := commit("a.txt", "1", "add a")
+	commit("b.txt", "1", "add b")
+	c3 := commit("a.txt", "2", "change a")
+	gitCmd("checkout", "-q", "-b", "side")
+	s1 := commit("a.txt", "3", "change a on side")
+	gitCmd("checkout", "-q", "main")
+	commit("b.txt", "2", "change b")
+	day++
+	gitCmd("merge", "-q", "--no-edit", "side")
+	head := gitCmd("rev-parse", "HEAD")
+
+	local, err := git.PlainOpen(dir)
+	assert.NoError(t, err)
+	r := &GitTmplRepo{Repository: local}
+	hashes := func(entries []HistoryEntry) []string {
+		var hs []string
+		for _, e := range entries {
+			hs = append(hs, e.Hash)
+		}
+		return hs
+	}
+
+	entries, err := r.History(FileRef{CommitHash: head, FilePath: "a.txt"}, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{s1, c3, c1}, hashes(entries))
+	assert.Equal(t, "t <t@t>", entries[0].Author)
+	assert.Equal(t, "change a on side\n", entries[0].Message)
+	assert.Equal(t, 4, entries[0].Date.Day())
+	entries, err = r.History(FileRef{CommitHash: c3, FilePath: "a.txt"}, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{c3}, hashes(entries))
+	_, err = r.History(FileRef{CommitHash: c1, FilePath: "b.txt"}, 10)
+	assert.Equal(t, ErrFileNotFound, err)
+
+	s := server(r, func(s *Server) { s.History = r })
+	defer s.Close()
+	resp, err := http.Get(s.URL + "/history/" + head + "/a.txt?limit=2")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	var got []HistoryEntry
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&got))
+	assert.Equal(t, []string{s1, c3}, hashes(got))
+	resp, err = http.Get(s.URL + "/history/" + head + "/nope.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	resp, err = http.Get(s.URL + "/history/" + head + "/a.txt?limit=0")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
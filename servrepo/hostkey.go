@@ -0,0 +1,206 @@
+package servrepo
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"srcd.works/go-git.v4/plumbing/format/pktline"
+	"srcd.works/go-git.v4/plumbing/protocol/packp"
+	"srcd.works/go-git.v4/plumbing/transport"
+	"srcd.works/go-git.v4/plumbing/transport/client"
+	gitssh "srcd.works/go-git.v4/plumbing/transport/ssh"
+)
+
+// ErrNoHostKeyCallback is returned by the SSHTransport without a
+// HostKeyCallback, a remote is never talked to unverified by accident.
+var ErrNoHostKeyCallback = errors.New("no host key callback to verify the remote")
+
+// SSHTransport fetches over ssh like the ssh transport of go-git, which
+// can't be given a HostKeyCallback, but verifies the host key of the very
+// connection it fetches over. Only fetches, i.e. git-upload-pack, are
+// supported.
+type SSHTransport struct {
+	HostKeyCallback ssh.HostKeyCallback
+	// Timeout bounds the handshake, 0 means no timeout.
+	Timeout time.Duration
+}
+
+// InstallSSHTransport makes the fetches and the remote listings over ssh
+// verify the host key with callback.
+func InstallSSHTransport(callback ssh.HostKeyCallback) {
+	client.InstallProtocol("ssh", &SSHTransport{HostKeyCallback: callback, Timeout: 10 * time.Second})
+}
+
+// NewUploadPackSession connects to the remote at ep and starts
+// git-upload-pack, failing if the host key is rejected.
+func (t *SSHTransport) NewUploadPackSession(ep transport.Endpoint, auth transport.AuthMethod) (transport.UploadPackSession, error) {
+	if t.HostKeyCallback == nil {
+		return nil, ErrNoHostKeyCallback
+	}
+	config, err := sshClientConfig(ep, auth)
+	if err != nil {
+		return nil, err
+	}
+	addr := ep.Host
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "22")
+	}
+	var keyErr error
+	config.HostKeyCallback = func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		keyErr = t.HostKeyCallback(hostname, remote, key)
+		return keyErr
+	}
+	config.Timeout = t.Timeout
+
+	conn, err := ssh.Dial("tcp", addr, config)
+	if keyErr != nil {
+		return nil, fmt.Errorf("verify host key of %s: %v", addr, keyErr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	sess, err := newUploadPackSession(conn, fmt.Sprintf("%s '%s'", transport.UploadPackServiceName, ep.Path))
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return sess, nil
+}
+
+// NewReceivePackSession fails, the server never pushes.
+func (t *SSHTransport) NewReceivePackSession(transport.Endpoint, transport.AuthMethod) (transport.ReceivePackSession, error) {
+	return nil, errors.New("pushing over ssh is not supported")
+}
+
+// sshClientConfig builds the client config of the go-git ssh auth, the ssh
+// agent is used if there is none.
+func sshClientConfig(ep transport.Endpoint, auth transport.AuthMethod) (*ssh.ClientConfig, error) {
+	if auth == nil {
+		user := ""
+		if ep.User != nil {
+			user = ep.User.Username()
+		}
+		agent, err := gitssh.NewSSHAgentAuth(user)
+		if err != nil {
+			return nil, err
+		}
+		auth = agent
+	}
+	switch a := auth.(type) {
+	case *gitssh.PublicKeys:
+		return &ssh.ClientConfig{User: a.User, Auth: []ssh.AuthMethod{ssh.PublicKeys(a.Signer)}}, nil
+	case *gitssh.PublicKeysCallback:
+		return &ssh.ClientConfig{User: a.User, Auth: []ssh.AuthMethod{ssh.PublicKeysCallback(a.Callback)}}, nil
+	case *gitssh.Password:
+		return &ssh.ClientConfig{User: a.User, Auth: []ssh.AuthMethod{ssh.Password(a.Pass)}}, nil
+	case *gitssh.PasswordCallback:
+		return &ssh.ClientConfig{User: a.User, Auth: []ssh.AuthMethod{ssh.PasswordCallback(a.Callback)}}, nil
+	case *gitssh.KeyboardInteractive:
+		return &ssh.ClientConfig{User: a.User, Auth: []ssh.AuthMethod{ssh.KeyboardInteractiveChallenge(a.Challenge)}}, nil
+	}
+	return nil, transport.ErrInvalidAuthMethod
+}
+
+// uploadPackSession speaks the git-upload-pack protocol over an ssh session,
+// the way the session of go-git does.
+type uploadPackSession struct {
+	conn    *ssh.Client
+	session *ssh.Session
+	stdin   io.WriteCloser
+	stdout  io.Reader
+
+	advRefs *packp.AdvRefs
+	packRun bool
+}
+
+func newUploadPackSession(conn *ssh.Client, cmd string) (*uploadPackSession, error) {
+	session, err := conn.NewSession()
+	if err != nil {
+		return nil, err
+	}
+	s := &uploadPackSession{conn: conn, session: session}
+	if s.stdin, err = session.StdinPipe(); err != nil {
+		return nil, err
+	}
+	if s.stdout, err = session.StdoutPipe(); err != nil {
+		return nil, err
+	}
+	if err := session.Start(cmd); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *uploadPackSession) AdvertisedReferences() (*packp.AdvRefs, error) {
+	if s.advRefs != nil {
+		return s.advRefs, nil
+	}
+	ar := packp.NewAdvRefs()
+	switch err := ar.Decode(s.stdout); err {
+	case nil:
+	case packp.ErrEmptyAdvRefs:
+		return nil, transport.ErrEmptyRemoteRepository
+	case packp.ErrEmptyInput:
+		return nil, transport.ErrRepositoryNotFound
+	default:
+		return nil, err
+	}
+	transport.FilterUnsupportedCapabilities(ar.Capabilities)
+	s.advRefs = ar
+	return ar, nil
+}
+
+func (s *uploadPackSession) UploadPack(req *packp.UploadPackRequest) (*packp.UploadPackResponse, error) {
+	if req.IsEmpty() {
+		return nil, transport.ErrEmptyUploadPackRequest
+	}
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
+	if _, err := s.AdvertisedReferences(); err != nil {
+		return nil, err
+	}
+	s.packRun = true
+
+	if err := req.UploadRequest.Encode(s.stdin); err != nil {
+		return nil, fmt.Errorf("sending upload-req message: %v", err)
+	}
+	if err := req.UploadHaves.Encode(s.stdin, true); err != nil {
+		return nil, fmt.Errorf("sending haves message: %v", err)
+	}
+	if err := pktline.NewEncoder(s.stdin).Encodef("done\n"); err != nil {
+		return nil, fmt.Errorf("sending done message: %v", err)
+	}
+	if err := s.stdin.Close(); err != nil {
+		return nil, fmt.Errorf("closing input: %v", err)
+	}
+	res := packp.NewUploadPackResponse(req)
+	if err := res.Decode(sessionReader{s.stdout, s.session}); err != nil {
+		return nil, fmt.Errorf("error decoding upload-pack response: %v", err)
+	}
+	return res, nil
+}
+
+// Close ends the session, with a flush packet if no pack was asked for so
+// the remote exits cleanly.
+func (s *uploadPackSession) Close() error {
+	if !s.packRun {
+		s.stdin.Write(pktline.FlushPkt)
+	}
+	s.session.Close()
+	return s.conn.Close()
+}
+
+// sessionReader reads the pack from the output of the session, closing it
+// waits for git-upload-pack to exit.
+type sessionReader struct {
+	io.Reader
+	session *ssh.Session
+}
+
+func (r sessionReader) Close() error { return r.session.Wait() }
@@ -0,0 +1,117 @@
+package servrepo
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+
+	"srcd.works/go-git.v4"
+	"srcd.works/go-git.v4/config"
+	"srcd.works/go-git.v4/plumbing/transport"
+	"srcd.works/go-git.v4/plumbing/transport/client"
+	gitssh "srcd.works/go-git.v4/plumbing/transport/ssh"
+	"srcd.works/go-git.v4/storage/memory"
+)
+
+// sshServer accepts ssh handshakes with a fresh host key and rejects every
+// client auth but the public keys given.
+func sshServer(t *testing.T, authorized ...ssh.PublicKey) (addr string, hostKey ssh.PublicKey, stop func()) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	signer, err := ssh.NewSignerFromKey(priv)
+	assert.NoError(t, err)
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(ssh.ConnMetadata, []byte) (*ssh.Permissions, error) {
+			return nil, errors.New("denied")
+		},
+		PublicKeyCallback: func(_ ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			for _, k := range authorized {
+				if bytes.Equal(k.Marshal(), key.Marshal()) {
+					return nil, nil
+				}
+			}
+			return nil, errors.New("denied")
+		},
+	}
+	config.AddHostKey(signer)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, chans, reqs, err := ssh.NewServerConn(conn, config)
+				if err != nil {
+					return
+				}
+				go ssh.DiscardRequests(reqs)
+				for nc := range chans {
+					serveEmptyRepo(nc)
+				}
+			}()
+		}
+	}()
+	return l.Addr().String(), signer.PublicKey(), func() { l.Close() }
+}
+
+// serveEmptyRepo answers a git-upload-pack run on the channel as an empty
+// repo would.
+func serveEmptyRepo(nc ssh.NewChannel) {
+	ch, reqs, err := nc.Accept()
+	if err != nil {
+		return
+	}
+	go func() {
+		for req := range reqs {
+			if req.Type != "exec" {
+				req.Reply(false, nil)
+				continue
+			}
+			req.Reply(true, nil)
+			ch.Write([]byte("0000"))
+			ch.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{0}))
+			ch.Close()
+		}
+	}()
+}
+
+func TestSSHTransport(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	signer, err := ssh.NewSignerFromKey(priv)
+	assert.NoError(t, err)
+	addr, hostKey, stop := sshServer(t, signer.PublicKey())
+	defer stop()
+	local, err := git.Init(memory.NewStorage(), nil)
+	assert.NoError(t, err)
+	_, err = local.CreateRemote(&config.RemoteConfig{Name: "origin", URL: "ssh://git@" + addr + "/repo.git"})
+	assert.NoError(t, err)
+	r := &GitTmplRepo{Repository: local, Auth: &gitssh.PublicKeys{User: "git", Signer: signer}}
+
+	defer func(ssh transport.Transport) { client.InstallProtocol("ssh", ssh) }(client.Protocols["ssh"])
+	InstallSSHTransport(ssh.FixedHostKey(hostKey))
+	// the host key is verified and the repo listed over the same connection
+	_, err = r.ListRemote()
+	assert.Equal(t, transport.ErrEmptyRemoteRepository, err)
+
+	InstallSSHTransport(func(string, net.Addr, ssh.PublicKey) error { return errors.New("unknown host") })
+	_, err = r.ListRemote()
+	assert.EqualError(t, err, "verify host key of "+addr+": unknown host")
+	err = r.Sync()
+	assert.EqualError(t, err, "failed to sync: verify host key of "+addr+": unknown host")
+
+	InstallSSHTransport(nil)
+	_, err = r.ListRemote()
+	assert.Equal(t, ErrNoHostKeyCallback, err)
+}
@@ -0,0 +1,89 @@
+package servrepo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// MaxIncludeDepth limits how deep templates may include each other.
+const MaxIncludeDepth = 8
+
+// funcs are the functions templates are parsed with, the ones depending on
+// what is being rendered are placeholders here and get bound by renderRef.
+var funcs = template.FuncMap{
+	"include": func(target string) (string, error) {
+		return "", errors.New("include is not available here")
+	},
+}
+
+// ErrRenderTimeout fails the functions called once the render budget of a
+// request is exhausted, see Server.RenderTimeout.
+var ErrRenderTimeout = errors.New("render budget is exhausted")
+
+// checkBudget fails with ErrRenderTimeout if ctx is done.
+func checkBudget(ctx context.Context) error {
+	if ctx.Err() != nil {
+		return ErrRenderTimeout
+	}
+	return nil
+}
+
+// parseIncludeRef parses the argument of include, which is either
+// "hash::path" or just a path referring to the commit being rendered.
+func parseIncludeRef(target string, current FileRef) FileRef {
+	if pos := strings.Index(target, "::"); pos >= 0 {
+		return FileRef{CommitHash: strings.ToLower(target[:pos]), FilePath: target[pos+2:]}
+	}
+	return FileRef{CommitHash: current.CommitHash, FilePath: target}
+}
+
+// renderRef renders tpl of ref with `include` bound to render other files of
+// the repo with the same data. The stack holds the refs being rendered, an
+// include of any of them is a cycle and fails the render, as does nesting
+// deeper than MaxIncludeDepth. The random functions draw from rng and the
+// time functions tell now. Includes fail once ctx is done.
+func renderRef(ctx context.Context, repo TmplRepo, ref FileRef, tpl *template.Template, data map[string]interface{}, rng *rand.Rand, now time.Time, stack []string) ([]byte, error) {
+	key := ref.String()
+	for _, k := range stack {
+		if k == key {
+			return nil, fmt.Errorf("include cycle: %s -> %s", strings.Join(stack, " -> "), key)
+		}
+	}
+	if len(stack) >= MaxIncludeDepth {
+		return nil, fmt.Errorf("includes are nested deeper than %d", MaxIncludeDepth)
+	}
+	// copy on append, sibling includes must not share the backing array
+	stack = append(stack[:len(stack):len(stack)], key)
+
+	// cached templates are shared, so bind the functions on a clone
+	tpl, err := tpl.Clone()
+	if err != nil {
+		return nil, err
+	}
+	tpl.Funcs(template.FuncMap{
+		"include": func(target string) (string, error) {
+			if err := checkBudget(ctx); err != nil {
+				return "", err
+			}
+			inc := parseIncludeRef(target, ref)
+			t, err := repo.GetTemplate(inc, true)
+			if err != nil {
+				return "", fmt.Errorf("include %s: %v", inc.String(), err)
+			}
+			if err := checkBudget(ctx); err != nil {
+				return "", err
+			}
+			out, err := renderRef(ctx, repo, inc, t, data, rng, now, stack)
+			return string(out), err
+		},
+	})
+	tpl.Funcs(randFuncs(rng))
+	tpl.Funcs(nowFuncs(now))
+	tpl.Funcs(controlFuncs(renderControlOf(ctx)))
+	return render(tpl, data)
+}
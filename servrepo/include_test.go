@@ -0,0 +1,99 @@
+package servrepo
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseIncludeRef(t *testing.T) {
+	cur := FileRef{CommitHash: INIT_COMMIT, FilePath: "a.txt"}
+	assert.Equal(t, FileRef{INIT_COMMIT, "b/c.txt"}, parseIncludeRef("b/c.txt", cur))
+	assert.Equal(t, FileRef{strings.Repeat("a", 40), "d.txt"}, parseIncludeRef(strings.Repeat("A", 40)+"::d.txt", cur))
+}
+
+func TestInclude(t *testing.T) {
+	other := strings.Repeat("1", 40)
+	repo := memRepo{
+		INIT_COMMIT + "::main.txt":    `[{{ include "part.txt" }}|{{ include "` + other + `::part.txt" }}]`,
+		INIT_COMMIT + "::part.txt":    "new {{ .who }}",
+		other + "::part.txt":          "old {{ .who }}",
+		INIT_COMMIT + "::twice.txt":   `{{ include "part.txt" }},{{ include "part.txt" }}`,
+		INIT_COMMIT + "::self.txt":    `{{ include "self.txt" }}`,
+		INIT_COMMIT + "::a.txt":       `{{ include "b.txt" }}`,
+		INIT_COMMIT + "::b.txt":       `{{ include "a.txt" }}`,
+		INIT_COMMIT + "::missing.txt": `{{ include "nope.txt" }}`,
+	}
+	for i := 0; i <= MaxIncludeDepth; i++ {
+		repo[fmt.Sprintf("%s::deep%d.txt", INIT_COMMIT, i)] = fmt.Sprintf(`{{ include "deep%d.txt" }}`, i+1)
+	}
+	s := server(repo)
+	defer s.Close()
+
+	get := func(name string) (int, string) {
+		resp, err := http.Get(s.URL + "/raw/" + INIT_COMMIT + "/" + name + "?who=world")
+		assert.NoError(t, err)
+		body, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	status, body := get("main.txt")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "[new world|old world]", body)
+
+	status, body = get("twice.txt")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "new world,new world", body)
+
+	for _, name := range []string{"self.txt", "a.txt"} {
+		status, body = get(name)
+		assert.Equal(t, http.StatusInternalServerError, status)
+		assert.Contains(t, body, "include cycle")
+	}
+
+	status, body = get("deep0.txt")
+	assert.Equal(t, http.StatusInternalServerError, status)
+	assert.Contains(t, body, "nested deeper")
+
+	status, body = get("missing.txt")
+	assert.Equal(t, http.StatusInternalServerError, status)
+	assert.Contains(t, body, ErrFileNotFound.Error())
+}
+
+func TestRenderTimeout(t *testing.T) {
+	funcs["slow"] = func() string { time.Sleep(50 * time.Millisecond); return "slow" }
+	defer delete(funcs, "slow")
+	repo := memRepo{
+		INIT_COMMIT + "::a.txt": `{{ slow }} {{ include "b.txt" }}`,
+		INIT_COMMIT + "::b.txt": `b`,
+	}
+	get := func(s *httptest.Server) (int, string) {
+		resp, err := http.Get(s.URL + "/raw/" + INIT_COMMIT + "/a.txt")
+		assert.NoError(t, err)
+		body, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	s := server(repo)
+	code, body := get(s)
+	s.Close()
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "slow b", body)
+
+	s = server(repo, func(s *Server) { s.RenderTimeout = time.Second })
+	code, _ = get(s)
+	s.Close()
+	assert.Equal(t, http.StatusOK, code)
+
+	s = server(repo, func(s *Server) { s.RenderTimeout = 10 * time.Millisecond })
+	defer s.Close()
+	code, body = get(s)
+	assert.Equal(t, http.StatusGatewayTimeout, code)
+	assert.Contains(t, body, ErrRenderTimeout.Error())
+}
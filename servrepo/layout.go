@@ -0,0 +1,80 @@
+package servrepo
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"text/template"
+)
+
+// LayoutContent is the name a layout renders the requested template by, i.e.
+// {{ template "content" . }}.
+const LayoutContent = "content"
+
+// LoadLayout loads a layout from a local file, see Server.LocalLayout.
+func LoadLayout(path string, missingKey string) (*template.Template, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseTemplate(FileRef{FilePath: path}, raw, missingKey)
+}
+
+// useLayout tells whether the request is rendered into the layout, which it
+// can opt out of by ?layout=false.
+func (s *Server) useLayout(ref FileRef, r *http.Request) (bool, error) {
+	if (len(s.Layout) == 0 && s.LocalLayout == nil) || ref.FilePath == s.Layout {
+		return false, nil
+	}
+	v := r.FormValue("layout")
+	if len(v) == 0 {
+		return true, nil
+	}
+	on, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("invalid layout %q, expect true or false", v)
+	}
+	return on, nil
+}
+
+// withLayout returns the layout with tpl added as LayoutContent, along with
+// the templates tpl defines. The combined templates are cached, by the
+// layout and tpl, which live in commits like the templates themselves.
+func (s *Server) withLayout(ref FileRef, tpl *template.Template) (*template.Template, error) {
+	layout, key := s.LocalLayout, "local layout"
+	if layout == nil {
+		lref := FileRef{CommitHash: ref.CommitHash, FilePath: s.Layout}
+		var err error
+		if layout, err = s.Repo.GetTemplate(lref, true); err != nil {
+			return nil, fmt.Errorf("layout %s: %v", lref.String(), err)
+		}
+		key = lref.String()
+	}
+	key += " + " + ref.String()
+	if s.layouts != nil {
+		if cached, ok := s.layouts.Get(key); ok {
+			return cached.(*template.Template), nil
+		}
+	}
+	combined, err := layout.Clone()
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range tpl.Templates() {
+		if t.Tree == nil {
+			continue
+		}
+		name := t.Name()
+		if name == tpl.Name() {
+			name = LayoutContent
+		}
+		if _, err := combined.AddParseTree(name, t.Tree); err != nil {
+			return nil, err
+		}
+	}
+	if s.layouts != nil {
+		s.layouts.Add(key, combined)
+	}
+	return combined, nil
+}
@@ -0,0 +1,58 @@
+package servrepo
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLayout(t *testing.T) {
+	m := memRepo{
+		INIT_COMMIT + "::layout.html": `<title>{{ block "title" . }}untitled{{ end }}</title><main>{{ template "content" . }}</main>`,
+		INIT_COMMIT + "::page.html":   `hi {{ .who }}{{ define "title" }}greeting{{ end }}`,
+		INIT_COMMIT + "::plain.html":  `bye {{ .who }}`,
+	}
+	get := func(url string) (int, string) {
+		resp, err := http.Get(url)
+		assert.NoError(t, err)
+		body, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	s := server(m, func(s *Server) { s.Layout = "layout.html" })
+	defer s.Close()
+	url := s.URL + "/raw/" + INIT_COMMIT
+	for i := 0; i < 2; i++ {
+		status, body := get(url + "/page.html?who=bob")
+		assert.Equal(t, http.StatusOK, status)
+		assert.Equal(t, "<title>greeting</title><main>hi bob</main>", body)
+	}
+	status, body := get(url + "/plain.html?who=bob")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "<title>untitled</title><main>bye bob</main>", body)
+	status, body = get(url + "/page.html?who=bob&layout=false")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "hi bob", body)
+	status, body = get(url + "/page.html?who=bob&template=title")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "greeting", body)
+	status, _ = get(url + "/page.html?who=bob&layout=maybe")
+	assert.Equal(t, http.StatusBadRequest, status)
+
+	dir, err := ioutil.TempDir("", "layout")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "layout.txt")
+	assert.NoError(t, ioutil.WriteFile(path, []byte(`[{{ template "content" . }}]`), 0644))
+	layout, err := LoadLayout(path, "")
+	assert.NoError(t, err)
+	s2 := server(m, func(s *Server) { s.LocalLayout = layout })
+	defer s2.Close()
+	status, body = get(s2.URL + "/raw/" + INIT_COMMIT + "/plain.html?who=bob")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "[bye bob]", body)
+}
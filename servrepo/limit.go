@@ -0,0 +1,69 @@
+package servrepo
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// ErrTooManyRequests is the 503 error of requests the ConcurrencyLimiter has
+// no room for.
+var ErrTooManyRequests = errors.New("too many concurrent requests, try again later")
+
+// ConcurrencyLimiter lets at most max requests be served at once, up to
+// queueSize more wait for their turn and the others are rejected with a 503.
+type ConcurrencyLimiter struct {
+	// Exempt lists the paths, and the paths under them, which are never
+	// limited, e.g. health checks.
+	Exempt []string
+
+	admitted chan struct{}
+	running  chan struct{}
+}
+
+// NewConcurrencyLimiter returns a limiter with the health checks, the metrics
+// and the log stream, which is held open for long, exempted.
+func NewConcurrencyLimiter(max, queueSize int) (*ConcurrencyLimiter, error) {
+	if max <= 0 || queueSize < 0 {
+		return nil, errors.New("max must be positive and queue size must not be negative")
+	}
+	return &ConcurrencyLimiter{
+		Exempt:   []string{"/ping", "/readyz", "/metrics", "/_admin/logs"},
+		admitted: make(chan struct{}, max+queueSize),
+		running:  make(chan struct{}, max),
+	}, nil
+}
+
+func (l *ConcurrencyLimiter) exempted(p string) bool {
+	for _, e := range l.Exempt {
+		if p == e || strings.HasPrefix(p, strings.TrimSuffix(e, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// Handler limits the requests to handler, a request whose client goes away
+// while queued is dropped.
+func (l *ConcurrencyLimiter) Handler(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if l.exempted(r.URL.Path) {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		select {
+		case l.admitted <- struct{}{}:
+			defer func() { <-l.admitted }()
+		default:
+			checkFailure(ErrTooManyRequests, http.StatusServiceUnavailable, w)
+			return
+		}
+		select {
+		case l.running <- struct{}{}:
+			defer func() { <-l.running }()
+		case <-r.Context().Done():
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,56 @@
+package servrepo
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConcurrencyLimiter(t *testing.T) {
+	_, err := NewConcurrencyLimiter(0, 1)
+	assert.Error(t, err)
+
+	l, err := NewConcurrencyLimiter(1, 1)
+	assert.NoError(t, err)
+	started, release := make(chan struct{}, 2), make(chan struct{})
+	h := l.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !l.exempted(r.URL.Path) {
+			started <- struct{}{}
+			<-release
+		}
+	}))
+	get := func(url string) int {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", url, nil))
+		return w.Code
+	}
+
+	// one running, one queued
+	var wg sync.WaitGroup
+	codes := make([]int, 2)
+	for i := range codes {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			codes[i] = get("/raw/x")
+		}(i)
+	}
+	<-started
+	for len(l.admitted) < 2 {
+		// wait for the second request to be queued
+		runtime.Gosched()
+	}
+
+	assert.Equal(t, http.StatusServiceUnavailable, get("/raw/x"))
+	for _, p := range []string{"/ping", "/ping/git", "/readyz", "/metrics", "/_admin/logs"} {
+		assert.Equal(t, http.StatusOK, get(p), p)
+	}
+
+	close(release)
+	wg.Wait()
+	assert.Equal(t, []int{http.StatusOK, http.StatusOK}, codes)
+}
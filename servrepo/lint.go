@@ -0,0 +1,131 @@
+package servrepo
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+	"text/template/parse"
+)
+
+// SourceReader reads the source of a template, see GitTmplRepo.ReadSource.
+type SourceReader interface {
+	ReadSource(ref FileRef) ([]byte, error)
+}
+
+// The kinds of lint diagnostics.
+const (
+	LintSyntaxError       = "syntax-error"
+	LintUndefinedFunction = "undefined-function"
+	LintUndefinedTemplate = "undefined-template"
+)
+
+type diagnostic struct {
+	Kind    string `json:"kind"`
+	Line    int    `json:"line,omitempty"`
+	Message string `json:"message"`
+}
+
+// LintHandler parses the template without rendering it and reports what's
+// wrong with it as a json list of diagnostics, an empty list means the
+// template is fine.
+func (s *Server) LintHandler(sources SourceReader, extract func(r *http.Request) (FileRef, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ref, err := extract(r)
+		if s.checkFailure(err, http.StatusBadRequest, w) {
+			return
+		}
+		src, err := sources.ReadSource(ref)
+		switch err {
+		case nil:
+		case ErrCommitNotFound, ErrFileNotFound:
+			s.checkFailure(err, http.StatusNotFound, w)
+			return
+		case ErrFileForbidden:
+			s.checkFailure(err, http.StatusForbidden, w)
+			return
+		default:
+			s.checkFailure(err, http.StatusInternalServerError, w)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(lint(effectivePath(ref.FilePath), src))
+	}
+}
+
+// lint parses src with the funcs available to templates. Parsing stops at the
+// first error, so a broken template gets a single diagnostic; otherwise every
+// {{ template }} call to an undefined template is reported.
+func lint(name string, src []byte) []diagnostic {
+	diags := []diagnostic{}
+	tpl, err := template.New(name).Funcs(funcs).Parse(string(src))
+	if err != nil {
+		return append(diags, parseDiagnostic(err))
+	}
+	for _, t := range tpl.Templates() {
+		if t.Tree == nil {
+			continue
+		}
+		walkNodes(t.Tree.Root, func(n parse.Node) {
+			call, ok := n.(*parse.TemplateNode)
+			if !ok || tpl.Lookup(call.Name) != nil {
+				return
+			}
+			diags = append(diags, diagnostic{
+				Kind:    LintUndefinedTemplate,
+				Line:    call.Line,
+				Message: fmt.Sprintf("template %q not defined", call.Name),
+			})
+		})
+	}
+	return diags
+}
+
+var parseErrorPattern = regexp.MustCompile(`^template: .*?:(\d+): (.*)$`)
+
+func parseDiagnostic(err error) diagnostic {
+	diag := diagnostic{Kind: LintSyntaxError, Message: err.Error()}
+	if m := parseErrorPattern.FindStringSubmatch(err.Error()); m != nil {
+		diag.Line, _ = strconv.Atoi(m[1])
+		diag.Message = m[2]
+	}
+	if strings.HasPrefix(diag.Message, "function ") && strings.HasSuffix(diag.Message, " not defined") {
+		diag.Kind = LintUndefinedFunction
+	}
+	return diag
+}
+
+// walkNodes calls fn on n and all the nodes under it.
+func walkNodes(n parse.Node, fn func(parse.Node)) {
+	if n == nil {
+		return
+	}
+	fn(n)
+	switch n := n.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, c := range n.Nodes {
+			walkNodes(c, fn)
+		}
+	case *parse.IfNode:
+		walkBranch(&n.BranchNode, fn)
+	case *parse.RangeNode:
+		walkBranch(&n.BranchNode, fn)
+	case *parse.WithNode:
+		walkBranch(&n.BranchNode, fn)
+	}
+}
+
+func walkBranch(n *parse.BranchNode, fn func(parse.Node)) {
+	if n.List != nil {
+		walkNodes(n.List, fn)
+	}
+	if n.ElseList != nil {
+		walkNodes(n.ElseList, fn)
+	}
+}
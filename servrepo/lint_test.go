@@ -0,0 +1,44 @@
+package servrepo
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLint(t *testing.T) {
+	for src, expect := range map[string][]diagnostic{
+		"Hi, {{ .who }}!": {},
+		"a\n{{ myFunc }}": {{Kind: LintUndefinedFunction, Line: 2, Message: `function "myFunc" not defined`}},
+		"{{ if .x }}":     {{Kind: LintSyntaxError, Line: 1, Message: "unexpected EOF"}},
+		`{{ define "a" }}a{{ end }}{{ template "a" }}{{ if .x }}{{ template "b" }}{{ else }}{{ template "c" . }}{{ end }}`: {
+			{Kind: LintUndefinedTemplate, Line: 1, Message: `template "b" not defined`},
+			{Kind: LintUndefinedTemplate, Line: 1, Message: `template "c" not defined`},
+		},
+	} {
+		assert.Equal(t, expect, lint("t.txt", []byte(src)), src)
+	}
+}
+
+func TestLintHandler(t *testing.T) {
+	gitRepo := repo(t, "..", 0).(*GitTmplRepo)
+	r := mux.NewRouter()
+	r.Path("/lint/" + HashPattern + "/" + PathPattern).HandlerFunc(NewServer(gitRepo).LintHandler(gitRepo, ExtractRefFromMuxVars))
+	s := httptest.NewServer(r)
+	defer s.Close()
+
+	resp, err := http.Get(s.URL + "/lint/" + INIT_COMMIT + "/templates/hi.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+	var diags []diagnostic
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&diags))
+	assert.Empty(t, diags)
+
+	resp, err = http.Get(s.URL + "/lint/" + INIT_COMMIT + "/templates/nope.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
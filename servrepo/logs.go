@@ -0,0 +1,128 @@
+package servrepo
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MaxLogLines caps the number of lines a LogBuffer keeps.
+const MaxLogLines = 10000
+
+// LogBuffer is a writer for the log package keeping the recent lines in a
+// ring buffer and broadcasting new ones to the subscribers, see LogsHandler.
+type LogBuffer struct {
+	mu      sync.Mutex
+	lines   []string
+	next    int
+	full    bool
+	partial []byte
+	subs    map[chan string]struct{}
+}
+
+// NewLogBuffer returns a LogBuffer keeping up to size lines, at most
+// MaxLogLines.
+func NewLogBuffer(size int) (*LogBuffer, error) {
+	if size <= 0 {
+		return nil, errors.New("size of log buffer must be positive")
+	}
+	if size > MaxLogLines {
+		size = MaxLogLines
+	}
+	return &LogBuffer{lines: make([]string, size), subs: make(map[chan string]struct{})}, nil
+}
+
+// Write records the complete lines of p, the rest is held until its newline
+// comes. Subscribers too slow to take a line miss it, so logging never blocks.
+func (b *LogBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.partial = append(b.partial, p...)
+	for {
+		i := bytes.IndexByte(b.partial, '\n')
+		if i < 0 {
+			break
+		}
+		line := string(b.partial[:i])
+		b.partial = b.partial[i+1:]
+		b.lines[b.next] = line
+		b.next = (b.next + 1) % len(b.lines)
+		b.full = b.full || b.next == 0
+		for sub := range b.subs {
+			select {
+			case sub <- line:
+			default:
+			}
+		}
+	}
+	return len(p), nil
+}
+
+// Recent returns the lines kept, the oldest first.
+func (b *LogBuffer) Recent() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.recent()
+}
+
+func (b *LogBuffer) recent() []string {
+	if !b.full {
+		return append([]string(nil), b.lines[:b.next]...)
+	}
+	return append(append([]string(nil), b.lines[b.next:]...), b.lines[:b.next]...)
+}
+
+// subscribe returns the recent lines and a channel of the lines to come,
+// which is open until cancel is called.
+func (b *LogBuffer) subscribe() (recent []string, lines chan string, cancel func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	lines = make(chan string, 256)
+	b.subs[lines] = struct{}{}
+	return b.recent(), lines, func() {
+		b.mu.Lock()
+		delete(b.subs, lines)
+		b.mu.Unlock()
+	}
+}
+
+// LogsHandler streams the recent log lines and the ones to come as server
+// sent events, one event per line, until the client goes away.
+func (s *Server) LogsHandler(logs *LogBuffer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			s.checkFailure(errors.New("streaming is not supported"), http.StatusInternalServerError, w)
+			return
+		}
+		// the stream outlives any write timeout of the http.Server
+		http.NewResponseController(w).SetWriteDeadline(time.Time{})
+		recent, lines, cancel := logs.subscribe()
+		defer cancel()
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		for _, line := range recent {
+			writeEvent(w, line)
+		}
+		flusher.Flush()
+		for {
+			select {
+			case line := <-lines:
+				writeEvent(w, line)
+				flusher.Flush()
+			case <-r.Context().Done():
+				return
+			}
+		}
+	}
+}
+
+// writeEvent writes a line as a server sent event, a carriage return would
+// end the data field early so it's dropped.
+func writeEvent(w http.ResponseWriter, line string) {
+	fmt.Fprintf(w, "data: %s\n\n", strings.Replace(line, "\r", "", -1))
+}
@@ -0,0 +1,66 @@
+package servrepo
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogBuffer(t *testing.T) {
+	b, err := NewLogBuffer(2)
+	assert.NoError(t, err)
+	fmt.Fprint(b, "a\nb")
+	assert.Equal(t, []string{"a"}, b.Recent())
+	fmt.Fprint(b, "\nc\n")
+	assert.Equal(t, []string{"b", "c"}, b.Recent())
+	_, err = NewLogBuffer(0)
+	assert.Error(t, err)
+}
+
+func TestLogsHandler(t *testing.T) {
+	logs, _ := NewLogBuffer(8)
+	fmt.Fprintln(logs, "before")
+	cache, _ := NewResponseCache(8, time.Minute)
+	access := &AccessLog{Logger: log.New(ioutil.Discard, "", 0)}
+	s := httptest.NewUnstartedServer(access.Handler(cache.Handler(NewServer(memRepo{}).LogsHandler(logs))))
+	s.Config.WriteTimeout = 50 * time.Millisecond
+	s.Start()
+	defer s.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequest("GET", s.URL, nil)
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	lines := bufio.NewReader(resp.Body)
+	readEvent := func() string {
+		line, err := lines.ReadString('\n')
+		assert.NoError(t, err)
+		lines.ReadString('\n')
+		return line
+	}
+	assert.Equal(t, "data: before\n", readEvent())
+	// the write timeout doesn't end the stream
+	time.Sleep(100 * time.Millisecond)
+	fmt.Fprintln(logs, "after")
+	assert.Equal(t, "data: after\n", readEvent())
+}
+
+func TestLogsRoute(t *testing.T) {
+	logs, _ := NewLogBuffer(8)
+	s := server(memRepo{}, func(s *Server) { s.AdminSecret = "s3cret"; s.Logs = logs })
+	defer s.Close()
+	resp, err := http.Get(s.URL + "/_admin/logs")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
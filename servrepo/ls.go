@@ -0,0 +1,83 @@
+package servrepo
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+const (
+	DefaultListLimit = 100
+	MaxListLimit     = 1000
+)
+
+// FileLister lists the files under the dir a FileRef refers to.
+type FileLister interface {
+	ListFiles(ref FileRef) ([]string, error)
+}
+
+type fileList struct {
+	Total  int      `json:"total"`
+	Offset int      `json:"offset"`
+	Limit  int      `json:"limit"`
+	Files  []string `json:"files"`
+}
+
+// LsHandler returns a page of the files under a dir as json, the page is
+// selected by the `offset` and `limit` query params, limit defaults to
+// DefaultListLimit and is capped at MaxListLimit.
+func (s *Server) LsHandler(lister FileLister, extract func(r *http.Request) (FileRef, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ref, err := extract(r)
+		if s.checkFailure(err, http.StatusBadRequest, w) {
+			return
+		}
+		offset, limit, err := parsePage(r)
+		if s.checkFailure(err, http.StatusBadRequest, w) {
+			return
+		}
+
+		files, err := lister.ListFiles(ref)
+		switch err {
+		case nil:
+		case ErrCommitNotFound:
+			s.checkFailure(err, http.StatusNotFound, w)
+			return
+		default:
+			log.Print("failed to list files: " + err.Error())
+			s.checkFailure(err, http.StatusInternalServerError, w)
+			return
+		}
+
+		page := fileList{Total: len(files), Offset: offset, Limit: limit, Files: []string{}}
+		if offset < len(files) {
+			end := offset + limit
+			if end > len(files) {
+				end = len(files)
+			}
+			page.Files = files[offset:end]
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(page)
+	}
+}
+
+func parsePage(r *http.Request) (offset int, limit int, err error) {
+	limit = DefaultListLimit
+	if s := r.FormValue("offset"); len(s) > 0 {
+		if offset, err = strconv.Atoi(s); err != nil || offset < 0 {
+			return 0, 0, errors.New("offset should be a non-negative integer")
+		}
+	}
+	if s := r.FormValue("limit"); len(s) > 0 {
+		if limit, err = strconv.Atoi(s); err != nil || limit <= 0 {
+			return 0, 0, errors.New("limit should be a positive integer")
+		}
+	}
+	if limit > MaxListLimit {
+		limit = MaxListLimit
+	}
+	return offset, limit, nil
+}
@@ -0,0 +1,72 @@
+package servrepo
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+type listerFunc func(ref FileRef) ([]string, error)
+
+func (f listerFunc) ListFiles(ref FileRef) ([]string, error) { return f(ref) }
+
+func lsServer(lister FileLister) *httptest.Server {
+	r := mux.NewRouter()
+	r.Path("/ls/" + HashPattern + "/" + PathPattern).HandlerFunc(NewServer(nil).LsHandler(lister, ExtractRefFromMuxVars))
+	return httptest.NewServer(r)
+}
+
+func getFileList(t *testing.T, url string) (int, fileList) {
+	var page fileList
+	resp, err := http.Get(url)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		assert.NoError(t, json.NewDecoder(resp.Body).Decode(&page))
+	}
+	return resp.StatusCode, page
+}
+
+func TestListFiles(t *testing.T) {
+	s := lsServer(repo(t, "..", 0).(*GitTmplRepo))
+	defer s.Close()
+
+	status, page := getFileList(t, s.URL+"/ls/"+INIT_COMMIT+"/templates")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, fileList{Total: 1, Limit: DefaultListLimit, Files: []string{"templates/hi.txt"}}, page)
+
+	status, _ = getFileList(t, s.URL+"/ls/0000000000000000000000000000000000000000/")
+	assert.Equal(t, http.StatusNotFound, status)
+}
+
+func TestLsHandlerPagination(t *testing.T) {
+	files := make([]string, 250)
+	for i := range files {
+		files[i] = string(rune('a'+i%26)) + ".txt"
+	}
+	s := lsServer(listerFunc(func(ref FileRef) ([]string, error) { return files, nil }))
+	defer s.Close()
+	url := s.URL + "/ls/" + INIT_COMMIT + "/"
+
+	_, page := getFileList(t, url)
+	assert.Equal(t, 250, page.Total)
+	assert.Len(t, page.Files, DefaultListLimit)
+
+	_, page = getFileList(t, url+"?offset=240&limit=20")
+	assert.Equal(t, files[240:], page.Files)
+
+	_, page = getFileList(t, url+"?offset=300")
+	assert.Equal(t, []string{}, page.Files)
+
+	_, page = getFileList(t, url+"?limit=100000")
+	assert.Equal(t, MaxListLimit, page.Limit)
+
+	for _, q := range []string{"?offset=-1", "?limit=0", "?limit=x"} {
+		status, _ := getFileList(t, url+q)
+		assert.Equal(t, http.StatusBadRequest, status, q)
+	}
+}
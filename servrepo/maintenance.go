@@ -0,0 +1,62 @@
+package servrepo
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+)
+
+var ErrMaintenance = errors.New("sync is skipped during maintenance")
+
+// Maintenance is a switch turned on while the git host is under maintenance,
+// syncs are skipped and the templates are served from the local repo as is.
+// A nil *Maintenance is never on.
+type Maintenance struct {
+	on int32
+}
+
+// On reports whether maintenance is on.
+func (m *Maintenance) On() bool {
+	return m != nil && atomic.LoadInt32(&m.on) == 1
+}
+
+// Set turns maintenance on or off.
+func (m *Maintenance) Set(on bool) {
+	var v int32
+	if on {
+		v = 1
+	}
+	atomic.StoreInt32(&m.on, v)
+}
+
+// MaintenanceWarning is the Warning header of the responses served during
+// maintenance.
+const MaintenanceWarning = `199 - "git host under maintenance, templates may be stale"`
+
+// MaintenanceHandler adds a Warning header to the responses while m is on.
+func MaintenanceHandler(m *Maintenance, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if m.On() {
+			w.Header().Add("Warning", MaintenanceWarning)
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// MaintenanceAdminHandler reports whether maintenance is on as json, a POST
+// with ?on=true or ?on=false turns it on or off first.
+func (s *Server) MaintenanceAdminHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			on, err := strconv.ParseBool(r.FormValue("on"))
+			if s.checkFailure(err, http.StatusBadRequest, w) {
+				return
+			}
+			s.Maintenance.Set(on)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]bool{"maintenance": s.Maintenance.On()})
+	}
+}
@@ -0,0 +1,54 @@
+package servrepo
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMaintenance(t *testing.T) {
+	var none *Maintenance
+	assert.False(t, none.On())
+
+	m := &Maintenance{}
+	m.Set(true)
+	assert.Equal(t, ErrMaintenance, (&GitTmplRepo{Maintenance: m}).Sync())
+
+	h := MaintenanceHandler(m, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, MaintenanceWarning, w.Header().Get("Warning"))
+
+	m.Set(false)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	assert.Empty(t, w.Header().Get("Warning"))
+}
+
+func TestMaintenanceAdminHandler(t *testing.T) {
+	m := &Maintenance{}
+	s := server(memRepo{}, func(s *Server) { s.AdminSecret = "s3cret"; s.Maintenance = m })
+	defer s.Close()
+	do := func(method, query string) (int, map[string]bool) {
+		req, _ := http.NewRequest(method, s.URL+"/_admin/maintenance"+query, nil)
+		req.Header.Set("Authorization", "Bearer s3cret")
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		var state map[string]bool
+		json.NewDecoder(resp.Body).Decode(&state)
+		return resp.StatusCode, state
+	}
+
+	_, state := do("POST", "?on=true")
+	assert.Equal(t, map[string]bool{"maintenance": true}, state)
+	assert.True(t, m.On())
+	_, state = do("GET", "")
+	assert.Equal(t, map[string]bool{"maintenance": true}, state)
+	code, _ := do("POST", "?on=maybe")
+	assert.Equal(t, http.StatusBadRequest, code)
+	_, state = do("POST", "?on=false")
+	assert.Equal(t, map[string]bool{"maintenance": false}, state)
+}
@@ -0,0 +1,95 @@
+package servrepo
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+type manifestEntry struct {
+	Path  string `json:"path"`
+	Size  int    `json:"size"`
+	MD5   string `json:"md5,omitempty"`
+	Blob  string `json:"blob,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+type manifest struct {
+	Total  int             `json:"total"`
+	Offset int             `json:"offset"`
+	Limit  int             `json:"limit"`
+	Files  []manifestEntry `json:"files"`
+}
+
+// ManifestHandler renders a page of the files under a dir with the data of
+// the request, like LsHandler pages them, and returns the path, the size and
+// the md5 of every output as json, as well as the blob hash of the file if
+// Blobs is set. The outputs themselves are left out, so clients can tell what
+// to fetch. A file failing to render has its error reported instead.
+func (s *Server) ManifestHandler(lister FileLister, extract func(r *http.Request) (FileRef, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		data, ref, ok := s.prepareRequest(extract, w, r)
+		if !ok {
+			return
+		}
+		offset, limit, err := parsePage(r)
+		if s.checkFailure(err, http.StatusBadRequest, w) {
+			return
+		}
+		now, err := renderTime(data)
+		if s.checkFailure(err, http.StatusBadRequest, w) {
+			return
+		}
+
+		files, err := lister.ListFiles(ref)
+		switch err {
+		case nil:
+		case ErrCommitNotFound:
+			s.checkFailure(err, http.StatusNotFound, w)
+			return
+		default:
+			log.Print("failed to list files: " + err.Error())
+			s.checkFailure(err, http.StatusInternalServerError, w)
+			return
+		}
+
+		page := manifest{Total: len(files), Offset: offset, Limit: limit, Files: []manifestEntry{}}
+		if offset < len(files) {
+			end := offset + limit
+			if end > len(files) {
+				end = len(files)
+			}
+			files = files[offset:end]
+		} else {
+			files = nil
+		}
+		ctx, cancel := s.renderContext(r)
+		defer cancel()
+		for _, p := range files {
+			entry := manifestEntry{Path: p}
+			file := FileRef{CommitHash: ref.CommitHash, FilePath: p}
+			tpl, err := s.Repo.GetTemplate(file, true)
+			var out []byte
+			if err == nil {
+				out, err = renderRef(ctx, s.Repo, file, tpl, data, newRand(data), now, nil)
+			}
+			if err != nil {
+				entry.Error = err.Error()
+				page.Files = append(page.Files, entry)
+				continue
+			}
+			sum := md5.Sum(out)
+			entry.Size, entry.MD5 = len(out), hex.EncodeToString(sum[:])
+			if s.Blobs != nil {
+				if entry.Blob, err = s.Blobs.BlobHash(file); err != nil {
+					log.Printf("failed to get blob hash of %s: %v", file.String(), err)
+				}
+			}
+			page.Files = append(page.Files, entry)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(page)
+	}
+}
@@ -0,0 +1,53 @@
+package servrepo
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"srcd.works/go-git.v4"
+)
+
+func TestManifestHandler(t *testing.T) {
+	local, err := git.PlainOpen("..")
+	assert.NoError(t, err)
+	gitRepo := &GitTmplRepo{Repository: local}
+	s := server(gitRepo, func(s *Server) { s.Files = gitRepo; s.Blobs = gitRepo })
+	defer s.Close()
+	get := func(query string) (int, manifest) {
+		var m manifest
+		resp, err := http.Get(s.URL + "/manifest/" + INIT_COMMIT + "/templates" + query)
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+		if resp.StatusCode == http.StatusOK {
+			assert.NoError(t, json.NewDecoder(resp.Body).Decode(&m))
+		}
+		return resp.StatusCode, m
+	}
+
+	sum := md5.Sum([]byte("Hi, world!\n"))
+	status, m := get("?who=world")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, manifest{Total: 1, Limit: DefaultListLimit, Files: []manifestEntry{{
+		Path: "templates/hi.txt",
+		Size: 11,
+		MD5:  hex.EncodeToString(sum[:]),
+		Blob: "c840f36ca9ac69e78ca19fb7abf681aa45d7e064",
+	}}}, m)
+
+	_, m = get("")
+	if assert.Len(t, m.Files, 1) {
+		assert.Contains(t, m.Files[0].Error, "map has no entry for key")
+		assert.Empty(t, m.Files[0].MD5)
+	}
+
+	_, m = get("?who=world&offset=1")
+	assert.Equal(t, 1, m.Total)
+	assert.Empty(t, m.Files)
+
+	status, _ = get("?who=world&limit=0")
+	assert.Equal(t, http.StatusBadRequest, status)
+}
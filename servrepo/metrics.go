@@ -0,0 +1,99 @@
+package servrepo
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// MaxRefLabels caps the distinct refs of a RefCounter, so a client asking
+// for many commits can't blow up the number of series.
+const MaxRefLabels = 10000
+
+// OtherRef is the label of the requests of the refs beyond the cap of a
+// RefCounter.
+const OtherRef = "other"
+
+// RefCounter counts the requests per ref, "hash::path", up to Max refs, the
+// requests of the refs beyond are counted as OtherRef. A nil RefCounter
+// counts nothing.
+type RefCounter struct {
+	Max int
+
+	mu     sync.Mutex
+	counts map[string]uint64
+	other  uint64
+}
+
+// NewRefCounter returns a counter of max refs at most.
+func NewRefCounter(max int) (*RefCounter, error) {
+	if max <= 0 || max > MaxRefLabels {
+		return nil, fmt.Errorf("max refs must be within 1 to %d", MaxRefLabels)
+	}
+	return &RefCounter{Max: max, counts: make(map[string]uint64)}, nil
+}
+
+// Inc counts a request of ref.
+func (c *RefCounter) Inc(ref FileRef) {
+	if c == nil {
+		return
+	}
+	key := ref.String()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.counts[key]; ok || len(c.counts) < c.Max {
+		c.counts[key]++
+		return
+	}
+	c.other++
+}
+
+// WriteMetrics writes the counts in the Prometheus text format, sorted by
+// ref.
+func (c *RefCounter) WriteMetrics(w http.ResponseWriter) {
+	c.mu.Lock()
+	refs := make([]string, 0, len(c.counts))
+	for ref := range c.counts {
+		refs = append(refs, ref)
+	}
+	sort.Strings(refs)
+	lines := make([]string, 0, len(refs)+3)
+	lines = append(lines,
+		"# HELP serv_repo_template_requests_total Requests per template ref, refs beyond the cap are counted as other.",
+		"# TYPE serv_repo_template_requests_total counter")
+	for _, ref := range refs {
+		lines = append(lines, fmt.Sprintf("serv_repo_template_requests_total{ref=%s} %d", labelValue(ref), c.counts[ref]))
+	}
+	lines = append(lines, fmt.Sprintf("serv_repo_template_requests_total{ref=%s} %d", labelValue(OtherRef), c.other))
+	c.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Header().Set("Cache-Control", "no-store")
+	fmt.Fprintln(w, strings.Join(lines, "\n"))
+}
+
+// labelValue quotes v as a label value, escaping backslashes, quotes and
+// newlines.
+func labelValue(v string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v) + `"`
+}
+
+// EvictionCounter tells the number of entries evicted from a cache so far.
+type EvictionCounter interface {
+	Evictions() uint64
+}
+
+// MetricsHandler serves the counts of c at /metrics, followed by the
+// evictions of the template cache if e is set.
+func MetricsHandler(c *RefCounter, e EvictionCounter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c.WriteMetrics(w)
+		if e != nil {
+			fmt.Fprintf(w, "# HELP serv_repo_template_cache_evictions_total Entries evicted from the template cache, invalidations included.\n"+
+				"# TYPE serv_repo_template_cache_evictions_total counter\n"+
+				"serv_repo_template_cache_evictions_total %d\n", e.Evictions())
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package servrepo
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRefCounter(t *testing.T) {
+	_, err := NewRefCounter(0)
+	assert.Error(t, err)
+
+	c, err := NewRefCounter(2)
+	assert.NoError(t, err)
+	repo := memRepo{
+		INIT_COMMIT + "::a.txt": "a",
+		INIT_COMMIT + "::b.txt": "b",
+		INIT_COMMIT + "::c.txt": "c",
+	}
+	s := server(repo, func(s *Server) { s.RefRequests = c })
+	defer s.Close()
+	for _, name := range []string{"a.txt", "b.txt", "a.txt", "c.txt", "c.txt"} {
+		resp, err := http.Get(s.URL + "/raw/" + INIT_COMMIT + "/" + name)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+	resp, err := http.Get(s.URL + "/md5/" + INIT_COMMIT + "/b.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, err = http.Get(s.URL + "/metrics")
+	assert.NoError(t, err)
+	assert.Equal(t, "no-store", resp.Header.Get("Cache-Control"))
+	body, _ := ioutil.ReadAll(resp.Body)
+	assert.Contains(t, string(body), "# TYPE serv_repo_template_requests_total counter\n"+
+		`serv_repo_template_requests_total{ref="`+INIT_COMMIT+`::a.txt"} 2`+"\n"+
+		`serv_repo_template_requests_total{ref="`+INIT_COMMIT+`::b.txt"} 2`+"\n"+
+		`serv_repo_template_requests_total{ref="other"} 2`+"\n")
+}
+
+func TestRefCounterResponseCache(t *testing.T) {
+	c, err := NewRefCounter(2)
+	assert.NoError(t, err)
+	srv := NewServer(memRepo{INIT_COMMIT + "::a.txt": "a"})
+	srv.RefRequests = c
+	cache, err := NewResponseCache(8, time.Minute)
+	assert.NoError(t, err)
+	cache.Refs = c
+	h := cache.Handler(srv.Handler())
+
+	for _, expect := range []string{"MISS", "HIT", "HIT"} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/raw/"+INIT_COMMIT+"/a.txt", nil))
+		assert.Equal(t, expect, w.Header().Get("X-Cache"))
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Contains(t, w.Body.String(), `serv_repo_template_requests_total{ref="`+INIT_COMMIT+`::a.txt"} 3`+"\n")
+}
+
+func TestMetricsCacheEvictions(t *testing.T) {
+	c, _ := NewRefCounter(2)
+	r, err := NewCachedTmplRepo(memRepo{
+		INIT_COMMIT + "::a.txt": "a",
+		INIT_COMMIT + "::b.txt": "b",
+	}, 1)
+	assert.NoError(t, err)
+	s := server(r, func(s *Server) { s.RefRequests = c; s.CacheEvictions = r.(*CachedTmplRepo) })
+	defer s.Close()
+	for _, name := range []string{"a.txt", "b.txt"} {
+		resp, err := http.Get(s.URL + "/raw/" + INIT_COMMIT + "/" + name)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+
+	resp, err := http.Get(s.URL + "/metrics")
+	assert.NoError(t, err)
+	body, _ := ioutil.ReadAll(resp.Body)
+	assert.Contains(t, string(body), "# TYPE serv_repo_template_cache_evictions_total counter\n"+
+		"serv_repo_template_cache_evictions_total 1\n")
+}
+
+func TestLabelValue(t *testing.T) {
+	assert.Equal(t, `"a"`, labelValue("a"))
+	assert.Equal(t, `"a\"b\\c\nd"`, labelValue("a\"b\\c\nd"))
+}
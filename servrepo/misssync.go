@@ -0,0 +1,55 @@
+package servrepo
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrMissSyncTimeout is returned by GetTemplate when the sync for a missing
+// commit takes longer than MissSyncTimeout, handlers answer it with a 504.
+var ErrMissSyncTimeout = errors.New("timed out syncing for the missing commit")
+
+// missSync is a sync started for a missing commit, shared by the requests
+// missing commits while it runs.
+type missSync struct {
+	mu      sync.Mutex
+	pending *pendingSync
+}
+
+type pendingSync struct {
+	done chan struct{}
+	err  error
+}
+
+// syncForMiss syncs for a missing commit, giving up after MissSyncTimeout
+// if it's set. The sync goes on after a timeout, and the requests missing
+// commits meanwhile wait for it instead of starting another one.
+func (r *GitTmplRepo) syncForMiss() error {
+	if r.MissSyncTimeout <= 0 {
+		return r.Sync()
+	}
+	r.miss.mu.Lock()
+	p := r.miss.pending
+	if p == nil {
+		p = &pendingSync{done: make(chan struct{})}
+		r.miss.pending = p
+		go func() {
+			p.err = r.Sync()
+			r.miss.mu.Lock()
+			r.miss.pending = nil
+			r.miss.mu.Unlock()
+			close(p.done)
+		}()
+	}
+	r.miss.mu.Unlock()
+
+	timer := time.NewTimer(r.MissSyncTimeout)
+	defer timer.Stop()
+	select {
+	case <-p.done:
+		return p.err
+	case <-timer.C:
+		return ErrMissSyncTimeout
+	}
+}
@@ -0,0 +1,108 @@
+package servrepo
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"srcd.works/go-billy.v1/osfs"
+	"srcd.works/go-git.v4"
+	"srcd.works/go-git.v4/config"
+	"srcd.works/go-git.v4/plumbing/transport"
+	"srcd.works/go-git.v4/plumbing/transport/client"
+	gitserver "srcd.works/go-git.v4/plumbing/transport/server"
+	"srcd.works/go-git.v4/storage/filesystem"
+	"srcd.works/go-git.v4/storage/memory"
+)
+
+func TestMissSyncTimeout(t *testing.T) {
+	// a remote accepting connections and never answering
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	var (
+		mu    sync.Mutex
+		conns []net.Conn
+	)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			conns = append(conns, conn)
+			mu.Unlock()
+		}
+	}()
+	defer func() {
+		l.Close()
+		mu.Lock()
+		defer mu.Unlock()
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}()
+
+	local, err := git.Init(memory.NewStorage(), nil)
+	assert.NoError(t, err)
+	_, err = local.CreateRemote(&config.RemoteConfig{Name: "origin", URL: "http://" + l.Addr().String() + "/tmpl.git"})
+	assert.NoError(t, err)
+	r := &GitTmplRepo{Repository: local, MissSyncTimeout: 50 * time.Millisecond}
+
+	ref := FileRef{CommitHash: INIT_COMMIT, FilePath: "templates/hi.txt"}
+	start := time.Now()
+	_, err = r.GetTemplate(ref, true)
+	assert.Equal(t, ErrMissSyncTimeout, err)
+	assert.True(t, time.Since(start) < time.Second)
+	_, err = r.GetTemplate(ref, false)
+	assert.Equal(t, ErrCommitNotFound, err)
+
+	s := server(r)
+	defer s.Close()
+	resp, err := http.Get(s.URL + "/raw/" + INIT_COMMIT + "/templates/hi.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusGatewayTimeout, resp.StatusCode)
+}
+
+func TestSyncForMissingCommit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "misssync")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	runGit(t, dir, "init", "-q")
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "hi.txt"), []byte("Hi, {{ .who }}!"), 0644))
+	runGit(t, dir, "add", "hi.txt")
+	runGit(t, dir, "commit", "-q", "-m", "first")
+	head := runGit(t, dir, "rev-parse", "HEAD")
+
+	// serve dir by go-git itself, git-upload-pack speaks a newer protocol
+	storage, err := filesystem.NewStorage(osfs.New(filepath.Join(dir, ".git")))
+	assert.NoError(t, err)
+	ep, err := transport.NewEndpoint("misssync://origin")
+	assert.NoError(t, err)
+	client.InstallProtocol("misssync", gitserver.NewServer(gitserver.MapLoader{ep: storage}))
+	local, err := git.Init(memory.NewStorage(), nil)
+	assert.NoError(t, err)
+	_, err = local.CreateRemote(&config.RemoteConfig{Name: "origin", URL: "misssync://origin"})
+	assert.NoError(t, err)
+
+	r := &GitTmplRepo{Repository: local, MissSyncTimeout: time.Minute}
+	ref := FileRef{CommitHash: head, FilePath: "hi.txt"}
+	_, err = r.GetTemplate(ref, false)
+	assert.Equal(t, ErrCommitNotFound, err)
+	_, err = r.GetTemplate(ref, true)
+	assert.NoError(t, err)
+
+	runGit(t, dir, "commit", "-q", "--allow-empty", "-m", "second")
+	head = runGit(t, dir, "rev-parse", "HEAD")
+	s := server(r, func(s *Server) { s.Sources = r })
+	defer s.Close()
+	resp, err := http.Get(s.URL + "/md5/" + head + "/hi.txt?of=source")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
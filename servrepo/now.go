@@ -0,0 +1,56 @@
+package servrepo
+
+import (
+	"errors"
+	"fmt"
+	"text/template"
+	"time"
+)
+
+// NowKey is the data key overriding the time the time functions of templates
+// tell, in RFC3339, e.g. ?__now=2024-01-02T15:04:05Z, so outputs holding
+// timestamps are reproducible. Without it they tell the time of the server.
+const NowKey = "__now"
+
+func init() {
+	for name, fn := range nowFuncs(time.Time{}) {
+		funcs[name] = fn
+	}
+}
+
+// nowFuncs returns the time functions of templates telling now, which is
+// shared by a render and its includes.
+func nowFuncs(now time.Time) template.FuncMap {
+	errNoNow := errors.New("time functions are not available here")
+	return template.FuncMap{
+		// now returns the time, e.g. {{ now.Year }}.
+		"now": func() (time.Time, error) {
+			if now.IsZero() {
+				return now, errNoNow
+			}
+			return now, nil
+		},
+		// nowFormat formats the time with a layout of package time, e.g.
+		// {{ nowFormat "2006-01-02" }}.
+		"nowFormat": func(layout string) (string, error) {
+			if now.IsZero() {
+				return "", errNoNow
+			}
+			return now.Format(layout), nil
+		},
+	}
+}
+
+// renderTime returns the time of NowKey in data, or the current time if
+// there is none.
+func renderTime(data map[string]interface{}) (time.Time, error) {
+	v, ok := data[NowKey]
+	if !ok {
+		return time.Now(), nil
+	}
+	now, err := time.Parse(time.RFC3339, fmt.Sprint(v))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid %s %q, expect an RFC3339 time", NowKey, v)
+	}
+	return now, nil
+}
@@ -0,0 +1,39 @@
+package servrepo
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRenderNow(t *testing.T) {
+	s := server(memRepo{
+		INIT_COMMIT + "::a.txt": `{{ nowFormat "2006-01-02" }} {{ now.Year }}{{ include "b.txt" }}`,
+		INIT_COMMIT + "::b.txt": ` {{ now.Format "15:04" }}`,
+	})
+	defer s.Close()
+	get := func(q string) (int, string) {
+		resp, err := http.Get(s.URL + "/raw/" + INIT_COMMIT + "/a.txt" + q)
+		assert.NoError(t, err)
+		body, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	status, body := get("?__now=2024-01-02T15:04:05Z")
+	assert.Equal(t, http.StatusOK, status, body)
+	assert.Equal(t, "2024-01-02 2024 15:04", body)
+	status, body = get("")
+	assert.Equal(t, http.StatusOK, status, body)
+	assert.Contains(t, body, time.Now().Format("2006-01-02")+" "+strconv.Itoa(time.Now().Year()))
+	status, _ = get("?__now=yesterday")
+	assert.Equal(t, http.StatusBadRequest, status)
+}
+
+func TestNowFuncsPlaceholder(t *testing.T) {
+	_, err := nowFuncs(time.Time{})["nowFormat"].(func(string) (string, error))("2006")
+	assert.Error(t, err)
+}
@@ -0,0 +1,99 @@
+package servrepo
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// MaxParseCheckFiles caps the number of files /_admin/parse-check parses.
+const MaxParseCheckFiles = 5000
+
+type parseFailure struct {
+	Path        string       `json:"path"`
+	Diagnostics []diagnostic `json:"diagnostics"`
+}
+
+type parseCheck struct {
+	Scanned   int            `json:"scanned"`
+	Truncated bool           `json:"truncated"`
+	Failed    []parseFailure `json:"failed"`
+}
+
+// ParseCheckHandler lints every file of the commit with the extensions of
+// ?ext=, e.g. .tmpl,.txt, or of ParseCheckExt if not given, all files if
+// neither is set. At most MaxParseCheckFiles are scanned. It reports the
+// files with diagnostics as json, with a 422 if there is any so CI can gate
+// on the status alone.
+func (s *Server) ParseCheckHandler(lister FileLister, sources SourceReader) http.HandlerFunc {
+	extract := ExtractRefWithPath("hash", "")
+	return func(w http.ResponseWriter, r *http.Request) {
+		ref, err := extract(r)
+		if s.checkFailure(err, http.StatusBadRequest, w) {
+			return
+		}
+		exts := s.ParseCheckExt
+		if v := r.FormValue("ext"); len(v) > 0 {
+			exts = strings.Split(v, ",")
+		}
+
+		files, err := lister.ListFiles(ref)
+		switch err {
+		case nil:
+		case ErrCommitNotFound:
+			s.checkFailure(err, http.StatusNotFound, w)
+			return
+		default:
+			log.Print("failed to list files: " + err.Error())
+			s.checkFailure(err, http.StatusInternalServerError, w)
+			return
+		}
+
+		check := parseCheck{Failed: []parseFailure{}}
+		for _, p := range files {
+			if !hasExt(p, exts) {
+				continue
+			}
+			if check.Scanned == MaxParseCheckFiles {
+				check.Truncated = true
+				break
+			}
+			check.Scanned++
+			file := FileRef{CommitHash: ref.CommitHash, FilePath: p}
+			src, err := sources.ReadSource(file)
+			if err != nil {
+				check.Failed = append(check.Failed, parseFailure{Path: p, Diagnostics: []diagnostic{{Kind: LintSyntaxError, Message: err.Error()}}})
+				continue
+			}
+			if diags := lint(effectivePath(p), src); len(diags) > 0 {
+				check.Failed = append(check.Failed, parseFailure{Path: p, Diagnostics: diags})
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if len(check.Failed) > 0 {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+		}
+		json.NewEncoder(w).Encode(check)
+	}
+}
+
+// hasExt tells whether the file, gzipped or not, has one of the extensions,
+// any file has if there is none.
+func hasExt(filePath string, exts []string) bool {
+	if len(exts) == 0 {
+		return true
+	}
+	ext := path.Ext(effectivePath(filePath))
+	for _, e := range exts {
+		e = strings.TrimSpace(e)
+		if !strings.HasPrefix(e, ".") {
+			e = "." + e
+		}
+		if strings.EqualFold(ext, e) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,59 @@
+package servrepo
+
+import (
+	"errors"
+	"log"
+	"math/rand"
+	"time"
+
+	"srcd.works/go-git.v4"
+)
+
+// PeriodicSync syncs Repo every Interval until stopped, each wait is moved
+// by up to Jitter of Interval either way, so a fleet of servers started
+// together doesn't fetch from the git host all at once.
+type PeriodicSync struct {
+	Repo     TmplRepo
+	Interval time.Duration
+	// Jitter is the fraction of Interval a wait may differ by, in [0, 1).
+	Jitter float64
+}
+
+// NewPeriodicSync returns a PeriodicSync of repo.
+func NewPeriodicSync(repo TmplRepo, interval time.Duration, jitter float64) (*PeriodicSync, error) {
+	if interval <= 0 {
+		return nil, errors.New("sync interval must be positive")
+	}
+	if jitter < 0 || jitter >= 1 {
+		return nil, errors.New("sync jitter must be in [0, 1)")
+	}
+	return &PeriodicSync{Repo: repo, Interval: interval, Jitter: jitter}, nil
+}
+
+// Run syncs until stop is closed, failures are logged.
+func (p *PeriodicSync) Run(stop <-chan struct{}) {
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+	for {
+		timer := time.NewTimer(jitter(p.Interval, p.Jitter, rng))
+		select {
+		case <-stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		switch err := p.Repo.Sync(); err {
+		case nil, git.NoErrAlreadyUpToDate, ErrMaintenance, ErrSyncSuspended:
+		default:
+			log.Print("failed to sync periodically: " + err.Error())
+		}
+	}
+}
+
+// jitter returns d moved by a random offset of up to fraction of d either
+// way.
+func jitter(d time.Duration, fraction float64, rng *rand.Rand) time.Duration {
+	if fraction <= 0 {
+		return d
+	}
+	return d + time.Duration((rng.Float64()*2-1)*fraction*float64(d))
+}
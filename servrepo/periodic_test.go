@@ -0,0 +1,52 @@
+package servrepo
+
+import (
+	"math/rand"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type countingRepo struct {
+	memRepo
+	syncs int32
+}
+
+func (r *countingRepo) Sync() error {
+	atomic.AddInt32(&r.syncs, 1)
+	return nil
+}
+
+func TestJitter(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	assert.Equal(t, time.Minute, jitter(time.Minute, 0, rng))
+	spread := false
+	for i := 0; i < 100; i++ {
+		d := jitter(time.Minute, 0.1, rng)
+		assert.True(t, d >= 54*time.Second && d <= 66*time.Second, d)
+		spread = spread || d != time.Minute
+	}
+	assert.True(t, spread)
+}
+
+func TestPeriodicSync(t *testing.T) {
+	_, err := NewPeriodicSync(memRepo{}, 0, 0)
+	assert.Error(t, err)
+	_, err = NewPeriodicSync(memRepo{}, time.Minute, 1)
+	assert.Error(t, err)
+
+	r := &countingRepo{}
+	p, err := NewPeriodicSync(r, 10*time.Millisecond, 0.5)
+	assert.NoError(t, err)
+	stop, done := make(chan struct{}), make(chan struct{})
+	go func() {
+		p.Run(stop)
+		close(done)
+	}()
+	time.Sleep(100 * time.Millisecond)
+	close(stop)
+	<-done
+	assert.True(t, atomic.LoadInt32(&r.syncs) >= 2)
+}
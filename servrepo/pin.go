@@ -0,0 +1,77 @@
+package servrepo
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"srcd.works/go-git.v4"
+)
+
+// PinnedTmplRepo is a TmplRepo pinned to the commit a ref resolves to, the
+// ref is resolved when the repo is created and again after every sync.
+type PinnedTmplRepo struct {
+	TmplRepo
+	Ref string
+	// TTL is how long the resolved commit is served before a request syncs
+	// to resolve the ref again, 0 means it's resolved again on syncs only.
+	TTL     time.Duration
+	resolve func(ref string) (string, error)
+	refresh refresher
+
+	mu   sync.RWMutex
+	hash string
+}
+
+func NewPinnedTmplRepo(repo TmplRepo, resolve func(ref string) (string, error), ref string) (*PinnedTmplRepo, error) {
+	r := &PinnedTmplRepo{TmplRepo: repo, Ref: ref, resolve: resolve}
+	if err := r.Resolve(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Resolve resolves the pinned ref again.
+func (r *PinnedTmplRepo) Resolve() error {
+	hash, err := r.resolve(r.Ref)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	r.hash = hash
+	r.mu.Unlock()
+	r.refresh.resolvedNow()
+	return nil
+}
+
+// Commit returns the commit hash the pinned ref currently resolves to.
+func (r *PinnedTmplRepo) Commit() string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.hash
+}
+
+func (r *PinnedTmplRepo) Sync() error {
+	err := r.TmplRepo.Sync()
+	if err != nil && err != git.NoErrAlreadyUpToDate {
+		return err
+	}
+	if e := r.Resolve(); e != nil {
+		return e
+	}
+	return err
+}
+
+// ExtractRef is an extractor which takes the file path from the "path" var of
+// the route and pairs it with the pinned commit, which is resolved again first
+// if it's older than TTL.
+func (r *PinnedTmplRepo) ExtractRef(req *http.Request) (FileRef, error) {
+	path, ok := mux.Vars(req)["path"]
+	if !ok {
+		return FileRef{}, errors.New("route has no \"path\" var")
+	}
+	r.refresh.refresh(r.TTL, r.Ref, r.Sync)
+	return FileRef{CommitHash: r.Commit(), FilePath: path}, nil
+}
@@ -0,0 +1,51 @@
+package servrepo
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// RemoteLister lists the references of a remote, see GitTmplRepo.ListRemote.
+type RemoteLister interface {
+	ListRemote() (map[string]string, error)
+}
+
+type pingResult struct {
+	OK        bool      `json:"ok"`
+	Error     string    `json:"error,omitempty"`
+	Duration  string    `json:"duration"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// PingGitHandler reports whether the remote is reachable with the configured
+// auth, with 200 or 503. A result is reused for interval so that frequent
+// probes don't hammer the remote.
+func PingGitHandler(lister RemoteLister, interval time.Duration) http.HandlerFunc {
+	var (
+		mu   sync.Mutex
+		last *pingResult
+	)
+	return func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		if last == nil || time.Since(last.CheckedAt) >= interval {
+			start := time.Now()
+			_, err := lister.ListRemote()
+			last = &pingResult{OK: err == nil, Duration: time.Since(start).String(), CheckedAt: start}
+			if err != nil {
+				last.Error = err.Error()
+			}
+		}
+		res := *last
+		mu.Unlock()
+
+		// the interval already bounds the calls, caches mustn't stretch it
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		if !res.OK {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(res)
+	}
+}
@@ -0,0 +1,52 @@
+package servrepo
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type remoteListerFunc func() (map[string]string, error)
+
+func (f remoteListerFunc) ListRemote() (map[string]string, error) { return f() }
+
+func TestPingGitHandler(t *testing.T) {
+	calls := 0
+	var fail error
+	h := PingGitHandler(remoteListerFunc(func() (map[string]string, error) {
+		calls++
+		return nil, fail
+	}), 50*time.Millisecond)
+
+	ping := func() (int, pingResult) {
+		var res pingResult
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/ping/git", nil))
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&res))
+		return w.Code, res
+	}
+
+	status, res := ping()
+	assert.Equal(t, http.StatusOK, status)
+	assert.True(t, res.OK)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/ping/git", nil))
+	assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+
+	// the result is reused within the interval
+	fail = errors.New("unreachable")
+	status, _ = ping()
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, 1, calls)
+
+	time.Sleep(60 * time.Millisecond)
+	status, res = ping()
+	assert.Equal(t, http.StatusServiceUnavailable, status)
+	assert.Equal(t, "unreachable", res.Error)
+	assert.Equal(t, 2, calls)
+}
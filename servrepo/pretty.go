@@ -0,0 +1,55 @@
+package servrepo
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// prettyFormatters maps file extensions to the formatters of ?pretty=true.
+var prettyFormatters = map[string]func(out []byte) ([]byte, error){
+	".json": prettyJSON,
+}
+
+// prettify formats out by the formatter of the extension of filePath. ok is
+// false if there is none for the extension.
+func prettify(out []byte, filePath string) (formatted []byte, ok bool, err error) {
+	format, ok := prettyFormatters[strings.ToLower(path.Ext(filePath))]
+	if !ok {
+		return nil, false, nil
+	}
+	formatted, err = format(out)
+	return formatted, true, err
+}
+
+// prettyJSON indents the json out by two spaces, it fails if out isn't valid
+// json.
+func prettyJSON(out []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, out, "", "  "); err != nil {
+		return nil, fmt.Errorf("output isn't valid json: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// prettyOutput formats out if asked to by ?pretty=true, failures have been
+// written to w if ok is false.
+func (s *Server) prettyOutput(out []byte, ref FileRef, r *http.Request, w http.ResponseWriter) ([]byte, bool) {
+	if pretty, _ := strconv.ParseBool(r.FormValue("pretty")); !pretty {
+		return out, true
+	}
+	filePath := effectivePath(ref.FilePath)
+	formatted, ok, err := prettify(out, filePath)
+	if !ok {
+		s.checkFailure(fmt.Errorf("no formatter for %s files", path.Ext(filePath)), http.StatusBadRequest, w)
+		return nil, false
+	}
+	if s.checkFailure(err, http.StatusUnprocessableEntity, w) {
+		return nil, false
+	}
+	return formatted, true
+}
@@ -0,0 +1,35 @@
+package servrepo
+
+import (
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrettyJSON(t *testing.T) {
+	s := server(memRepo{
+		INIT_COMMIT + "::a.json":   `{"who":"{{ .who }}","n":[1,2]}`,
+		INIT_COMMIT + "::bad.json": `{"who":{{ .who }}}`,
+		INIT_COMMIT + "::a.txt":    `{"who":"{{ .who }}"}`,
+	})
+	defer s.Close()
+	get := func(path string) (int, string) {
+		resp, err := http.Get(s.URL + "/raw/" + INIT_COMMIT + path)
+		assert.NoError(t, err)
+		body, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	code, body := get("/a.json?who=world&pretty=true")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "{\n  \"who\": \"world\",\n  \"n\": [\n    1,\n    2\n  ]\n}", body)
+	_, body = get("/a.json?who=world")
+	assert.Equal(t, `{"who":"world","n":[1,2]}`, body)
+
+	code, _ = get("/bad.json?who=world&pretty=true")
+	assert.Equal(t, http.StatusUnprocessableEntity, code)
+	code, _ = get("/a.txt?who=world&pretty=true")
+	assert.Equal(t, http.StatusBadRequest, code)
+}
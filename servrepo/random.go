@@ -0,0 +1,66 @@
+package servrepo
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"reflect"
+	"text/template"
+	"time"
+)
+
+// SeedKey is the data key seeding the random functions of templates, e.g.
+// ?__seed=42, so the same seed renders the same output. Any string is a seed.
+// Without a seed, the output differs from a render to another.
+const SeedKey = "__seed"
+
+func init() {
+	for name, fn := range randFuncs(nil) {
+		funcs[name] = fn
+	}
+}
+
+// randFuncs returns the random functions of templates drawing from rng, which
+// is shared by a render and its includes.
+func randFuncs(rng *rand.Rand) template.FuncMap {
+	errNoRand := errors.New("random functions are not available here")
+	return template.FuncMap{
+		// randInt returns an int in [0,n).
+		"randInt": func(n int) (int, error) {
+			if rng == nil {
+				return 0, errNoRand
+			}
+			if n <= 0 {
+				return 0, fmt.Errorf("randInt: %d is not positive", n)
+			}
+			return rng.Intn(n), nil
+		},
+		// randItem returns an item of a slice or an array.
+		"randItem": func(list interface{}) (interface{}, error) {
+			if rng == nil {
+				return nil, errNoRand
+			}
+			v := reflect.ValueOf(list)
+			if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+				return nil, fmt.Errorf("randItem: can't pick from %T", list)
+			}
+			if v.Len() == 0 {
+				return nil, errors.New("randItem: can't pick from an empty list")
+			}
+			return v.Index(rng.Intn(v.Len())).Interface(), nil
+		},
+	}
+}
+
+// newRand returns a rand seeded by the SeedKey of data, or by the clock if
+// there is none.
+func newRand(data map[string]interface{}) *rand.Rand {
+	seed, ok := data[SeedKey]
+	if !ok {
+		return rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+	h := fnv.New64a()
+	fmt.Fprint(h, seed)
+	return rand.New(rand.NewSource(int64(h.Sum64())))
+}
@@ -0,0 +1,45 @@
+package servrepo
+
+import (
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRandFuncs(t *testing.T) {
+	fns := randFuncs(rand.New(rand.NewSource(1)))
+	item, err := fns["randItem"].(func(interface{}) (interface{}, error))([]string{"a"})
+	assert.NoError(t, err)
+	assert.Equal(t, "a", item)
+	_, err = fns["randItem"].(func(interface{}) (interface{}, error))([]string{})
+	assert.Error(t, err)
+	_, err = fns["randItem"].(func(interface{}) (interface{}, error))("abc")
+	assert.Error(t, err)
+	_, err = fns["randInt"].(func(int) (int, error))(0)
+	assert.Error(t, err)
+
+	_, err = randFuncs(nil)["randInt"].(func(int) (int, error))(1)
+	assert.Error(t, err)
+}
+
+func TestRenderSeed(t *testing.T) {
+	s := server(memRepo{
+		INIT_COMMIT + "::a.txt": `{{ randInt 1000000 }}{{ include "b.txt" }}`,
+		INIT_COMMIT + "::b.txt": `-{{ randInt 1000000 }}`,
+	})
+	defer s.Close()
+	get := func(q string) string {
+		resp, err := http.Get(s.URL + "/raw/" + INIT_COMMIT + "/a.txt" + q)
+		assert.NoError(t, err)
+		body, _ := ioutil.ReadAll(resp.Body)
+		assert.Equal(t, http.StatusOK, resp.StatusCode, string(body))
+		return string(body)
+	}
+
+	assert.Equal(t, get("?__seed=42"), get("?__seed=42"))
+	assert.NotEqual(t, get("?__seed=42"), get("?__seed=43"))
+	assert.NotEqual(t, get(""), get(""))
+}
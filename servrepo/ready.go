@@ -0,0 +1,65 @@
+package servrepo
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"text/template"
+)
+
+// CacheChecker checks a template cache is functioning, see
+// CachedTmplRepo.CheckCache.
+type CacheChecker interface {
+	CheckCache() error
+}
+
+// readySentinel is the cache key of the template CheckCache round-trips, it
+// can't collide with a FileRef, which always has a "::".
+const readySentinel = "_readyz"
+
+// CheckCache adds a sentinel template to the Backend and gets it back. The
+// in-process lru cache can't fail, so it's only checked if there's a Backend.
+func (r *CachedTmplRepo) CheckCache() error {
+	if r.Backend == nil {
+		return nil
+	}
+	sentinel := template.New(readySentinel)
+	if err := r.Backend.Add(readySentinel, sentinel); err != nil {
+		return err
+	}
+	got, ok, err := r.Backend.Get(readySentinel)
+	if err != nil {
+		return err
+	}
+	if !ok || got == nil || got.Name() != readySentinel {
+		return errors.New("sentinel template is missing from the cache")
+	}
+	return nil
+}
+
+type readyResult struct {
+	OK    bool   `json:"ok"`
+	Cache string `json:"cache,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// ReadyHandler reports whether the server is ready to take traffic with 200
+// or 503. It also checks the cache is functioning if cache isn't nil. The
+// report is never to be cached, a probe has to see the current state.
+func ReadyHandler(cache CacheChecker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		res := readyResult{OK: true}
+		if cache != nil {
+			res.Cache = "ok"
+			if err := cache.CheckCache(); err != nil {
+				res = readyResult{Cache: "failed", Error: err.Error()}
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		if !res.OK {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(res)
+	}
+}
@@ -0,0 +1,50 @@
+package servrepo
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"srcd.works/go-git.v4"
+)
+
+// refresher keeps the commit a symbolic ref, like a branch or a tag, was
+// resolved to from going stale. Templates are cached by commit hash, which
+// never changes, so only the resolution has to be done again.
+type refresher struct {
+	mu       sync.Mutex
+	resolved time.Time
+	busy     bool
+}
+
+// resolvedNow records that the ref has just been resolved.
+func (f *refresher) resolvedNow() {
+	f.mu.Lock()
+	f.resolved = time.Now()
+	f.mu.Unlock()
+}
+
+// refresh calls sync, which is expected to resolve the ref again, if it was
+// resolved more than ttl ago. Only one caller syncs at a time, the others go
+// on with the commit resolved before, as do all callers if the sync fails.
+func (f *refresher) refresh(ttl time.Duration, name string, sync func() error) {
+	if ttl <= 0 {
+		return
+	}
+	f.mu.Lock()
+	if f.busy || time.Since(f.resolved) < ttl {
+		f.mu.Unlock()
+		return
+	}
+	f.busy = true
+	f.mu.Unlock()
+
+	if err := sync(); err != nil && err != git.NoErrAlreadyUpToDate {
+		log.Printf("failed to refresh %s: %v", name, err)
+	}
+	f.mu.Lock()
+	// retry a failed sync no sooner than ttl either
+	f.resolved = time.Now()
+	f.busy = false
+	f.mu.Unlock()
+}
@@ -0,0 +1,1299 @@
+package servrepo
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"regexp"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"text/template"
+	"time"
+
+	"github.com/gorilla/mux"
+	lru "github.com/hashicorp/golang-lru"
+	"srcd.works/go-git.v4"
+	"srcd.works/go-git.v4/plumbing"
+	"srcd.works/go-git.v4/plumbing/object"
+	"srcd.works/go-git.v4/plumbing/transport"
+	"srcd.works/go-git.v4/plumbing/transport/client"
+)
+
+type FileRef struct {
+	CommitHash string
+	FilePath   string
+}
+
+func (r *FileRef) String() string {
+	return r.CommitHash + "::" + r.FilePath
+}
+
+type TmplRepo interface {
+	GetTemplate(ref FileRef, sync bool) (*template.Template, error)
+	Sync() error
+}
+
+type GitTmplRepo struct {
+	*git.Repository
+	Auth    transport.AuthMethod
+	Breaker *Breaker
+	// Maintenance skips syncs while it's on.
+	Maintenance *Maintenance
+	// AllowExt lists the extensions of the files allowed to be served, e.g.
+	// ".tmpl", other files are treated as forbidden. A gzipped file counts
+	// by the extension before .gz. Empty allows every file.
+	AllowExt []string
+	// Deny lists glob patterns of the files forbidden to be served, see
+	// matchGlob for the syntax.
+	Deny []string
+	// SkipUnchanged makes Sync check cheaply whether the remote changed, see
+	// RemoteChanged, and skip the fetch if it didn't. If the check fails, Sync
+	// falls back to fetching.
+	SkipUnchanged bool
+	// MissingKey tells how templates handle keys missing from the data, see
+	// MissingKeyOptions, empty means "error".
+	MissingKey string
+	// OpenSubmodule opens a submodule by name, e.g. from .git/modules, so
+	// files under submodules can be found. Nil doesn't follow submodules.
+	OpenSubmodule func(name string) (*git.Repository, error)
+	// MissSyncTimeout bounds the sync GetTemplate does for a missing commit,
+	// which fails with ErrMissSyncTimeout once it's spent. 0 waits for the
+	// sync to finish.
+	MissSyncTimeout time.Duration
+	// CheckoutRef is the commit, branch or tag whose files are read into
+	// memory by Checkout, and again by Sync when it moves, so its templates
+	// are found without walking the tree. Empty checks nothing out.
+	CheckoutRef string
+
+	miss     missSync
+	checkout worktree
+}
+
+var (
+	ErrCommitNotFound = errors.New("failed to find the commit in repo")
+	ErrFileNotFound   = errors.New("failed to find the file in commit")
+	ErrFileForbidden  = errors.New("the file is not allowed to be served")
+	ErrRefNotFound    = errors.New("failed to resolve the ref in repo")
+	ErrEmptyOutput    = errors.New("the template rendered nothing")
+)
+
+// ResolveRef resolves a commit hash, branch or tag to a commit hash. Branches
+// are looked up in the remote-tracking refs first since those are the ones
+// updated by Sync.
+func (r *GitTmplRepo) ResolveRef(ref string) (string, error) {
+	if len(ref) == 40 && strings.Trim(ref, "0123456789abcdef") == "" {
+		return ref, nil
+	}
+	for _, name := range []string{ref, "refs/remotes/origin/" + ref, "refs/tags/" + ref, "refs/heads/" + ref} {
+		res, err := r.Reference(plumbing.ReferenceName(name), true)
+		if err != nil {
+			continue
+		}
+		// annotated tags point to a tag object instead of a commit
+		if tag, err := r.Tag(res.Hash()); err == nil {
+			commit, err := tag.Commit()
+			if err != nil {
+				return "", err
+			}
+			return commit.Hash.String(), nil
+		}
+		return res.Hash().String(), nil
+	}
+	return "", ErrRefNotFound
+}
+
+// FindFile finds the file in the commit. A file forbidden by AllowExt or Deny
+// is rejected before the repo is looked at.
+func (r *GitTmplRepo) FindFile(ref FileRef) (*object.File, error) {
+	if !r.allowed(ref.FilePath) {
+		return nil, ErrFileForbidden
+	}
+	commit, err := r.Commit(plumbing.NewHash(ref.CommitHash))
+	if err != nil {
+		return nil, ErrCommitNotFound
+	}
+	return r.commitFile(commit, ref.FilePath)
+}
+
+// commitFile finds the file of the commit, following submodules if enabled.
+func (r *GitTmplRepo) commitFile(commit *object.Commit, filePath string) (*object.File, error) {
+	file, err := commit.File(filePath)
+	if err == nil {
+		return file, nil
+	}
+	if r.OpenSubmodule != nil {
+		return r.findSubmoduleFile(commit, filePath)
+	}
+	return nil, ErrFileNotFound
+}
+
+// BlobHash returns the hash of the git blob of the file, which only changes
+// with the content of the file, whatever its path and commit.
+func (r *GitTmplRepo) BlobHash(ref FileRef) (string, error) {
+	file, err := r.FindFile(ref)
+	if err != nil {
+		return "", err
+	}
+	return file.Hash.String(), nil
+}
+
+// allowed tells whether the file may be served according to AllowExt and
+// Deny.
+func (r *GitTmplRepo) allowed(filePath string) bool {
+	for _, pattern := range r.Deny {
+		if matchGlob(pattern, filePath) {
+			return false
+		}
+	}
+	if len(r.AllowExt) == 0 {
+		return true
+	}
+	ext := path.Ext(effectivePath(filePath))
+	for _, allowed := range r.AllowExt {
+		if strings.EqualFold(ext, allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+// ReadFile reads the file as stored in the local repo, along with the time
+// of the commit.
+func (r *GitTmplRepo) ReadFile(ref FileRef) ([]byte, time.Time, error) {
+	if !r.allowed(ref.FilePath) {
+		return nil, time.Time{}, ErrFileForbidden
+	}
+	commit, err := r.Commit(plumbing.NewHash(ref.CommitHash))
+	if err != nil {
+		return nil, time.Time{}, ErrCommitNotFound
+	}
+	file, err := r.commitFile(commit, ref.FilePath)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	in, err := file.Reader()
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	defer in.Close()
+	raw, err := ioutil.ReadAll(in)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	return raw, commit.Committer.When, nil
+}
+
+// ListFiles lists the sorted paths of the files under the dir ref.FilePath
+// in the commit, an empty dir means the whole tree. Files not allowed by
+// AllowExt are left out.
+func (r *GitTmplRepo) ListFiles(ref FileRef) ([]string, error) {
+	commit, err := r.Commit(plumbing.NewHash(ref.CommitHash))
+	if err != nil {
+		return nil, ErrCommitNotFound
+	}
+	iter, err := commit.Files()
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+
+	prefix := strings.Trim(ref.FilePath, "/")
+	if len(prefix) > 0 {
+		prefix += "/"
+	}
+	var paths []string
+	err = iter.ForEach(func(f *object.File) error {
+		if strings.HasPrefix(f.Name, prefix) && r.allowed(f.Name) {
+			paths = append(paths, f.Name)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// GetTemplate looks the file up in the local repo first, anything available
+// locally is served without touching the remote. Only a missing commit leads
+// to a sync (when sync is true) and a second lookup. A sync outlasting
+// MissSyncTimeout is returned as ErrMissSyncTimeout, and a sync failing with
+// ErrSyncFailed is returned if the commit is still missing after it; other
+// sync errors are logged and the second lookup decides.
+func (r *GitTmplRepo) GetTemplate(ref FileRef, sync bool) (*template.Template, error) {
+	return r.getTemplateTimed(ref, sync, nil)
+}
+
+func (r *GitTmplRepo) getTemplateTimed(ref FileRef, sync bool, timing *Timing) (*template.Template, error) {
+	raw, err := r.readSourceTimed(ref, sync, timing)
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	defer timing.Since("parse", start)
+	return parseTemplate(ref, raw, r.MissingKey)
+}
+
+// readSourceTimed reads the source of ref, a missing commit leads to a sync
+// (when sync is true) and a second lookup, see GetTemplate.
+func (r *GitTmplRepo) readSourceTimed(ref FileRef, sync bool, timing *Timing) ([]byte, error) {
+	start := time.Now()
+	raw, err := r.ReadSource(ref)
+	timing.Since("find", start)
+	if err != ErrCommitNotFound || !sync {
+		return raw, err
+	}
+
+	start = time.Now()
+	syncErr := r.syncForMiss()
+	timing.Since("sync", start)
+	if syncErr == ErrMissSyncTimeout {
+		log.Printf("timed out after %v syncing for missing commit %s", r.MissSyncTimeout, ref.CommitHash)
+		return nil, syncErr
+	}
+	if syncErr != nil && syncErr != git.NoErrAlreadyUpToDate {
+		log.Print("failed to sync for missing commit: " + syncErr.Error())
+	}
+	start = time.Now()
+	raw, err = r.ReadSource(ref)
+	timing.Since("find", start)
+	if _, failed := syncErr.(ErrSyncFailed); failed && err == ErrCommitNotFound {
+		return nil, syncErr
+	}
+	return raw, err
+}
+
+// syncedSourceReader reads a source syncing for a missing commit, like
+// GitTmplRepo does.
+type syncedSourceReader interface {
+	readSourceTimed(ref FileRef, sync bool, timing *Timing) ([]byte, error)
+}
+
+// parseTemplate parses the source of ref as a template named after ref, a
+// parse failure is reported as ErrParseFailed. Missing keys are handled as
+// missingKey tells, see MissingKeyOptions, empty means "error".
+func parseTemplate(ref FileRef, raw []byte, missingKey string) (*template.Template, error) {
+	name := FileRef{CommitHash: ref.CommitHash, FilePath: effectivePath(ref.FilePath)}
+	tpl, err := template.New(name.String()).Funcs(funcs).Parse(string(raw))
+	if err != nil {
+		return nil, ErrParseFailed{Err: err}
+	}
+	if len(missingKey) == 0 {
+		missingKey = "error"
+	}
+	return tpl.Option("missingkey=" + missingKey), nil
+}
+
+// MissingKeyOptions are the ways templates may handle a key missing from the
+// data, see the missingkey option of text/template. With "error" a missing
+// key fails the render with a 400, with "default" or "invalid" it renders as
+// "<no value>", as does "zero" since data values are interfaces.
+var MissingKeyOptions = []string{"error", "zero", "default", "invalid"}
+
+// CheckMissingKey fails if v isn't one of MissingKeyOptions.
+func CheckMissingKey(v string) error {
+	for _, o := range MissingKeyOptions {
+		if v == o {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown missingkey option %q, expect one of %s", v, strings.Join(MissingKeyOptions, ", "))
+}
+
+// ErrParseFailed is returned by GetTemplate when the file isn't a valid
+// template, it reads as the parse error.
+type ErrParseFailed struct {
+	Err error
+}
+
+func (e ErrParseFailed) Error() string {
+	return e.Err.Error()
+}
+
+// ReadSource reads the source of the file from the local repo, or from memory
+// if its commit is checked out, a gzipped source is decompressed.
+func (r *GitTmplRepo) ReadSource(ref FileRef) ([]byte, error) {
+	if !r.allowed(ref.FilePath) {
+		return nil, ErrFileForbidden
+	}
+	if raw, ok := r.checkedOut(ref); ok {
+		return decodeSource(ref.FilePath, raw)
+	}
+	file, err := r.FindFile(ref)
+	if err != nil {
+		return nil, err
+	}
+	in, err := file.Reader()
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+
+	raw, err := ioutil.ReadAll(in)
+	if err != nil {
+		return nil, err
+	}
+	return decodeSource(ref.FilePath, raw)
+}
+
+// effectivePath strips the .gz suffix of a gzipped source.
+func effectivePath(filePath string) string {
+	return strings.TrimSuffix(filePath, ".gz")
+}
+
+// decodeSource decompresses the source if it is a gzipped file.
+func decodeSource(filePath string, raw []byte) ([]byte, error) {
+	if path.Ext(filePath) != ".gz" {
+		return raw, nil
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(raw))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	return ioutil.ReadAll(zr)
+}
+
+func (r *GitTmplRepo) Sync() error {
+	if r.Maintenance.On() {
+		return ErrMaintenance
+	}
+	if !r.Breaker.Allow() {
+		return ErrSyncSuspended
+	}
+	if r.SkipUnchanged {
+		changed, err := r.RemoteChanged()
+		if err != nil {
+			log.Print("failed to check remote for changes, fetch anyway: " + err.Error())
+		} else if !changed {
+			r.Breaker.Record(true)
+			return git.NoErrAlreadyUpToDate
+		}
+	}
+	err := r.Fetch(&git.FetchOptions{Auth: r.Auth})
+	r.Breaker.Record(err == nil || err == git.NoErrAlreadyUpToDate)
+	if err != nil && err != git.NoErrAlreadyUpToDate {
+		return ErrSyncFailed{Err: err}
+	}
+	if len(r.CheckoutRef) > 0 {
+		if e := r.Checkout(); e != nil {
+			log.Print("failed to check out " + r.CheckoutRef + ": " + e.Error())
+		}
+	}
+	return err
+}
+
+// ErrSyncFailed is returned by Sync when talking to the remote fails, as
+// opposed to a sync being skipped, e.g. by ErrSyncSuspended. Handlers answer
+// it with a 502 since the remote is to blame. GetTemplate returns it when a
+// commit is still missing after the failed sync.
+type ErrSyncFailed struct {
+	Err error
+}
+
+func (e ErrSyncFailed) Error() string {
+	return "failed to sync: " + e.Err.Error()
+}
+
+// RemoteChanged tells whether a fetch would move any branch or tag, by
+// comparing the branch heads and tags advertised by the origin remote with the
+// remote-tracking refs and the tags of the local repo. Only the refs are
+// exchanged, no object is fetched.
+func (r *GitTmplRepo) RemoteChanged() (bool, error) {
+	refs, err := r.ListRemote()
+	if err != nil {
+		return false, err
+	}
+	return r.headsChanged(refs), nil
+}
+
+// headsChanged tells whether any of the branch heads in refs differs from its
+// remote-tracking ref, or any of the tags from the local tag, since tags are
+// fetched as well and can be moved by force.
+func (r *GitTmplRepo) headsChanged(refs map[string]string) bool {
+	for name, hash := range refs {
+		local := name
+		switch {
+		case strings.HasPrefix(name, "refs/heads/"):
+			local = "refs/remotes/" + git.DefaultRemoteName + "/" + strings.TrimPrefix(name, "refs/heads/")
+		case strings.HasPrefix(name, "refs/tags/"):
+		default:
+			continue
+		}
+		ref, err := r.Reference(plumbing.ReferenceName(local), false)
+		if err != nil || ref.Hash().String() != hash {
+			return true
+		}
+	}
+	return false
+}
+
+// ListRemote lists the references advertised by the origin remote without
+// fetching any object, just like `git ls-remote`.
+func (r *GitTmplRepo) ListRemote() (map[string]string, error) {
+	remote, err := r.Remote(git.DefaultRemoteName)
+	if err != nil {
+		return nil, err
+	}
+	ep, err := transport.NewEndpoint(remote.Config().URL)
+	if err != nil {
+		return nil, err
+	}
+	c, err := client.NewClient(ep)
+	if err != nil {
+		return nil, err
+	}
+	sess, err := c.NewUploadPackSession(ep, r.Auth)
+	if err != nil {
+		return nil, err
+	}
+	defer sess.Close()
+
+	ar, err := sess.AdvertisedReferences()
+	if err != nil {
+		return nil, err
+	}
+	refs := make(map[string]string, len(ar.References))
+	for name, hash := range ar.References {
+		refs[name] = hash.String()
+	}
+	return refs, nil
+}
+
+type CachedTmplRepo struct {
+	TmplRepo
+	Cache *lru.Cache
+	// LogEvictions logs every eviction from the cache.
+	LogEvictions bool
+	// NoCache lists glob patterns of the files never cached, see matchGlob
+	// for the syntax. They're loaded from the underlying repo every time.
+	NoCache []string
+	// Backend, if set, takes the place of Cache for the templates to be
+	// looked up and added, Keys still only lists Cache.
+	Backend TemplateCache
+	// FailClosed fails GetTemplate with ErrCacheFailed when the Backend
+	// fails. By default such errors are logged and the templates are loaded
+	// from the underlying repo as if they weren't cached.
+	FailClosed bool
+
+	evictions   uint64
+	cacheErrors logLimiter
+}
+
+func NewCachedTmplRepo(repo TmplRepo, size int) (TmplRepo, error) {
+	r := &CachedTmplRepo{TmplRepo: repo}
+	cache, err := lru.NewWithEvict(size, r.onEvicted)
+	if err != nil {
+		return nil, err
+	}
+	r.Cache = cache
+	return r, nil
+}
+
+func (r *CachedTmplRepo) onEvicted(key interface{}, value interface{}) {
+	atomic.AddUint64(&r.evictions, 1)
+	if r.LogEvictions {
+		log.Printf("evicted %s from template cache", key)
+	}
+}
+
+// Evictions returns the number of entries evicted from the cache so far,
+// explicit removals via Invalidate included.
+func (r *CachedTmplRepo) Evictions() uint64 {
+	return atomic.LoadUint64(&r.evictions)
+}
+
+func (r *CachedTmplRepo) GetTemplate(ref FileRef, sync bool) (*template.Template, error) {
+	return r.getTemplateTimed(ref, sync, nil)
+}
+
+func (r *CachedTmplRepo) getTemplateTimed(ref FileRef, sync bool, timing *Timing) (*template.Template, error) {
+	if r.uncached(ref.FilePath) {
+		return getTemplateTimed(r.TmplRepo, ref, sync, timing)
+	}
+	key := ref.String()
+	start := time.Now()
+	cached, ok, err := r.get(key)
+	timing.Since("cache", start)
+	if err != nil {
+		return nil, err
+	}
+	timing.Cached(ok)
+	if ok {
+		return cached, nil
+	}
+	tmpl, err := getTemplateTimed(r.TmplRepo, ref, sync, timing)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.add(key, tmpl); err != nil {
+		return nil, err
+	}
+	return tmpl, nil
+}
+
+func (r *CachedTmplRepo) get(key string) (*template.Template, bool, error) {
+	if r.Backend != nil {
+		return r.backendGet(key)
+	}
+	cached, ok := r.Cache.Get(key)
+	if !ok {
+		return nil, false, nil
+	}
+	return cached.(*template.Template), true, nil
+}
+
+func (r *CachedTmplRepo) add(key string, tmpl *template.Template) error {
+	if r.Backend != nil {
+		return r.backendAdd(key, tmpl)
+	}
+	r.Cache.Add(key, tmpl)
+	return nil
+}
+
+func (r *CachedTmplRepo) uncached(filePath string) bool {
+	for _, pattern := range r.NoCache {
+		if matchGlob(pattern, filePath) {
+			return true
+		}
+	}
+	return false
+}
+
+// Keys returns the keys of the cached templates, see FileRef.String, from the
+// least to the most recently used.
+func (r *CachedTmplRepo) Keys() []string {
+	keys := r.Cache.Keys()
+	refs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		refs = append(refs, key.(string))
+	}
+	return refs
+}
+
+// Invalidate removes the cached template of ref, if any.
+func (r *CachedTmplRepo) Invalidate(ref FileRef) {
+	r.Cache.Remove(ref.String())
+	if r.Backend != nil {
+		if err := r.Backend.Remove(ref.String()); err != nil {
+			r.logCacheError("remove", ref.String(), err)
+		}
+	}
+}
+
+// ExtractRefFromMuxVars extracts the file ref from the "hash" and "path" vars
+// of the route, see HashPattern and PathPattern.
+var ExtractRefFromMuxVars = ExtractRefFromVars("hash", "path")
+
+// ExtractRefFromVars returns an extractor which takes the commit hash and the
+// file path from the given vars of the route.
+func ExtractRefFromVars(hashVar, pathVar string) func(r *http.Request) (FileRef, error) {
+	return func(r *http.Request) (FileRef, error) {
+		vars := mux.Vars(r)
+		hash, ok := vars[hashVar]
+		if !ok {
+			return FileRef{}, fmt.Errorf("route has no %q var", hashVar)
+		}
+		path, ok := vars[pathVar]
+		if !ok {
+			return FileRef{}, fmt.Errorf("route has no %q var", pathVar)
+		}
+		return FileRef{
+			CommitHash: strings.ToLower(strings.TrimSpace(hash)),
+			FilePath:   path,
+		}, nil
+	}
+}
+
+// ExtractRefWithPath returns an extractor which takes the commit hash from the
+// given var of the route and always refers to filePath, e.g. for a route
+// passing data in its path, see Server.PathVars.
+func ExtractRefWithPath(hashVar, filePath string) func(r *http.Request) (FileRef, error) {
+	return func(r *http.Request) (FileRef, error) {
+		hash, ok := mux.Vars(r)[hashVar]
+		if !ok {
+			return FileRef{}, fmt.Errorf("route has no %q var", hashVar)
+		}
+		return FileRef{CommitHash: strings.ToLower(strings.TrimSpace(hash)), FilePath: filePath}, nil
+	}
+}
+
+// ExtractRefFromHeaders extracts the file ref from the X-Commit and X-Path
+// headers, for proxies which don't keep the path of the request. The hash is
+// checked like HashPattern does, a leading slash of the path is dropped.
+func ExtractRefFromHeaders(r *http.Request) (FileRef, error) {
+	hash := strings.TrimSpace(r.Header.Get("X-Commit"))
+	if !hashRegexp.MatchString(hash) {
+		return FileRef{}, fmt.Errorf("invalid X-Commit %q, expect 40 hex chars", hash)
+	}
+	path := strings.TrimPrefix(r.Header.Get("X-Path"), "/")
+	if len(path) == 0 {
+		return FileRef{}, errors.New("X-Path is missing")
+	}
+	return FileRef{CommitHash: strings.ToLower(hash), FilePath: path}, nil
+}
+
+// MaxMultiPaths caps the number of comma separated paths /raw renders at once.
+const MaxMultiPaths = 16
+
+func (s *Server) RawHandler(extract func(r *http.Request) (FileRef, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		data, ref, ok := s.prepareRequest(extract, w, r)
+		if !ok {
+			return
+		}
+		paths := splitPaths(ref.FilePath)
+		switch stream := r.FormValue("stream"); {
+		case stream == "ndjson" && len(paths) > 0:
+			s.renderStream(ref.CommitHash, paths, data, w, r)
+			return
+		case len(stream) > 0 && stream != "ndjson":
+			s.checkFailure(fmt.Errorf("unknown stream format %q, expect ndjson", stream), http.StatusBadRequest, w)
+			return
+		}
+		switch len(paths) {
+		case 0:
+		case 1:
+			ref.FilePath = paths[0]
+		default:
+			s.renderMulti(ref.CommitHash, paths, data, w, r)
+			return
+		}
+		r, ctl := withRenderControl(r)
+		out, ok := s.renderFile(ref, r.FormValue("template"), data, w, r)
+		if !ok || s.checkFailure(ctl.check(), http.StatusInternalServerError, w) {
+			return
+		}
+		if out, ok = s.prettyOutput(out, ref, r, w); !ok {
+			return
+		}
+		eol := r.FormValue("eol")
+		if len(eol) > 0 {
+			var err error
+			if out, err = normalizeEOL(out, eol); s.checkFailure(err, http.StatusBadRequest, w) {
+				return
+			}
+		}
+		if algo := r.FormValue("footer"); len(algo) > 0 {
+			var err error
+			if out, err = appendFooter(out, algo, effectivePath(ref.FilePath), s.FooterComment); s.checkFailure(err, http.StatusBadRequest, w) {
+				return
+			}
+			if len(eol) > 0 {
+				// the footer line itself ends with a plain newline
+				out, _ = normalizeEOL(out, eol)
+			}
+		}
+		ctype := mime.TypeByExtension(path.Ext(effectivePath(ref.FilePath)))
+		if charset := r.FormValue("charset"); len(charset) > 0 {
+			var err error
+			out, charset, err = transcode(out, charset)
+			if _, unsupported := err.(errUnsupportedCharset); unsupported {
+				s.checkFailure(err, http.StatusBadRequest, w)
+				return
+			}
+			if s.checkFailure(err, http.StatusUnprocessableEntity, w) {
+				return
+			}
+			ctype = withCharset(ctype, charset)
+		}
+		if bom, _ := strconv.ParseBool(r.FormValue("bom")); bom {
+			// the BOM is left out of checksums, /md5 and ?footer= see the
+			// output without it
+			if charset := r.FormValue("charset"); len(charset) > 0 && !strings.EqualFold(charset, "utf-8") && !strings.EqualFold(charset, "utf8") {
+				s.checkFailure(fmt.Errorf("a BOM can't be added to output in %s", charset), http.StatusBadRequest, w)
+				return
+			}
+			out = append([]byte(utf8BOM), out...)
+		}
+		if encoding := r.FormValue("encoding"); len(encoding) > 0 {
+			var err error
+			if out, err = encodeOutput(out, encoding); s.checkFailure(err, http.StatusBadRequest, w) {
+				return
+			}
+			w.Header().Set("Content-Encoding", encoding)
+		}
+		if len(ctype) > 0 {
+			w.Header().Set("Content-Type", ctype)
+		}
+		s.setBlobHash(w, ref)
+		setDisposition(w, r, ref)
+		ctl.apply(w)
+		w.Write(out)
+	}
+}
+
+// splitPaths splits a comma separated list of paths, a path without comma
+// results in itself only.
+func splitPaths(filePath string) []string {
+	var paths []string
+	for _, p := range strings.Split(filePath, ",") {
+		if len(p) > 0 {
+			paths = append(paths, p)
+		}
+	}
+	return paths
+}
+
+// renderMulti renders every path of the commit with the same data and writes
+// a json object of path to output. It fails as a whole if any of the paths
+// fails, with the status that path would have got alone.
+func (s *Server) renderMulti(commit string, paths []string, data map[string]interface{}, w http.ResponseWriter, r *http.Request) {
+	if len(paths) > MaxMultiPaths {
+		s.checkFailure(fmt.Errorf("too many paths, at most %d are allowed", MaxMultiPaths), http.StatusBadRequest, w)
+		return
+	}
+	outs := make(map[string]string, len(paths))
+	for _, p := range paths {
+		out, ok := s.renderFile(FileRef{CommitHash: commit, FilePath: p}, "", data, w, r)
+		if !ok {
+			return
+		}
+		outs[p] = string(out)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(outs)
+}
+
+// utf8BOM is prepended to the output of /raw with ?bom=true.
+const utf8BOM = "\xef\xbb\xbf"
+
+// MD5Handler writes the md5 of the rendered template, or of the template
+// source itself with ?of=source.
+func (s *Server) MD5Handler(extract func(r *http.Request) (FileRef, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var (
+			ref FileRef
+			out []byte
+			ok  bool
+		)
+		switch of := r.FormValue("of"); of {
+		case "", "render":
+			ref, out, ok = s.checksumRequest(extract, w, r)
+		case "source":
+			ref, out, ok = s.sourceRequest(extract, w, r)
+		default:
+			s.checkFailure(fmt.Errorf("unknown checksum subject %q, expect render or source", of), http.StatusBadRequest, w)
+		}
+		if !ok {
+			return
+		}
+
+		s.setBlobHash(w, ref)
+		hash := md5.New()
+		hash.Write(out)
+		w.Write([]byte(hex.EncodeToString(hash.Sum(nil)) + "  " + path.Base(effectivePath(ref.FilePath)) + "\n"))
+	}
+}
+
+// checksumRequest renders the request for a checksum, with ?pretty= and
+// ?eol= applied like /raw does, failures have been written to w if ok is
+// false.
+func (s *Server) checksumRequest(extract func(r *http.Request) (FileRef, error), w http.ResponseWriter, r *http.Request) (ref FileRef, out []byte, ok bool) {
+	ref, out, ok = s.renderRequest(extract, w, r)
+	if ok {
+		out, ok = s.prettyOutput(out, ref, r, w)
+	}
+	if eol := r.FormValue("eol"); ok && len(eol) > 0 {
+		var err error
+		out, err = normalizeEOL(out, eol)
+		ok = !s.checkFailure(err, http.StatusBadRequest, w)
+	}
+	return ref, out, ok
+}
+
+// passthrough reads the source of ref failing to parse with err, to be
+// served as is, as text/plain unless its extension tells otherwise.
+func (s *Server) passthrough(ref FileRef, err error, w http.ResponseWriter) ([]byte, bool) {
+	raw, rerr := s.Sources.ReadSource(ref)
+	if s.checkFailure(rerr, http.StatusInternalServerError, w) {
+		return nil, false
+	}
+	log.Printf("serve %s as is: %v", ref.String(), err)
+	ctype := mime.TypeByExtension(path.Ext(effectivePath(ref.FilePath)))
+	if len(ctype) == 0 {
+		ctype = "text/plain; charset=utf-8"
+	}
+	w.Header().Set("Content-Type", ctype)
+	return raw, true
+}
+
+// renderRequest renders the template referred by the request with the data
+// it carries, failures have been written to w if ok is false.
+func (s *Server) renderRequest(extract func(r *http.Request) (FileRef, error), w http.ResponseWriter, r *http.Request) (ref FileRef, out []byte, ok bool) {
+	data, ref, ok := s.prepareRequest(extract, w, r)
+	if !ok {
+		return
+	}
+	out, ok = s.renderFile(ref, r.FormValue("template"), data, w, r)
+	return ref, out, ok
+}
+
+// sourceRequest reads the unrendered source of the requested template, a
+// missing commit leads to a sync like GetTemplate does, bounded by the same
+// MissSyncTimeout when Sources is a GitTmplRepo. Failures have been written
+// to w if ok is false.
+func (s *Server) sourceRequest(extract func(r *http.Request) (FileRef, error), w http.ResponseWriter, r *http.Request) (ref FileRef, out []byte, ok bool) {
+	if s.Sources == nil {
+		s.checkFailure(errors.New("reading sources is not supported"), http.StatusBadRequest, w)
+		return
+	}
+	ref, err := extract(r)
+	if s.checkFailure(err, http.StatusBadRequest, w) {
+		return
+	}
+	if synced, ok := s.Sources.(syncedSourceReader); ok {
+		out, err = synced.readSourceTimed(ref, true, nil)
+	} else if out, err = s.Sources.ReadSource(ref); err == ErrCommitNotFound {
+		syncErr := s.Repo.Sync()
+		if syncErr != nil && syncErr != git.NoErrAlreadyUpToDate {
+			log.Print("failed to sync for missing commit: " + syncErr.Error())
+		}
+		if out, err = s.Sources.ReadSource(ref); err == ErrCommitNotFound {
+			if _, failed := syncErr.(ErrSyncFailed); failed {
+				err = syncErr
+			}
+		}
+	}
+	switch err {
+	case nil:
+	case ErrCommitNotFound, ErrFileNotFound:
+		s.checkFailure(err, http.StatusNotFound, w)
+		return
+	case ErrFileForbidden:
+		s.checkFailure(err, http.StatusForbidden, w)
+		return
+	case ErrMissSyncTimeout:
+		s.checkFailure(err, http.StatusGatewayTimeout, w)
+		return
+	default:
+		if _, failed := err.(ErrSyncFailed); failed {
+			s.checkFailure(err, http.StatusBadGateway, w)
+			return
+		}
+		s.checkFailure(err, http.StatusInternalServerError, w)
+		return
+	}
+	return ref, out, true
+}
+
+// prepareRequest parses the data and extracts the file ref of the request.
+// Failures have been written to w if ok is false.
+func (s *Server) prepareRequest(extract func(r *http.Request) (FileRef, error), w http.ResponseWriter, r *http.Request) (data map[string]interface{}, ref FileRef, ok bool) {
+	// prepare data
+	s.setVary(w, r)
+	data, err := s.parseData(r)
+	if err == ErrBodyTooLarge {
+		s.checkFailure(err, http.StatusRequestEntityTooLarge, w)
+		return
+	}
+	if s.checkFailure(err, http.StatusBadRequest, w) {
+		return
+	}
+
+	// extract file ref
+	ref, err = extract(r)
+	if err == ErrBranchNotTracked || err == ErrNoMatchingTag {
+		s.checkFailure(err, http.StatusNotFound, w)
+		return
+	}
+	if s.checkFailure(err, http.StatusBadRequest, w) {
+		return
+	}
+	s.RefRequests.Inc(ref)
+	noteRef(r, ref)
+	return data, ref, true
+}
+
+// renderContext returns the context of rendering r, which is done once the
+// RenderTimeout is spent.
+func (s *Server) renderContext(r *http.Request) (context.Context, context.CancelFunc) {
+	if s.RenderTimeout > 0 {
+		return context.WithTimeout(r.Context(), s.RenderTimeout)
+	}
+	return context.WithCancel(r.Context())
+}
+
+// renderFile gets and renders the template of ref, or the template defined in
+// it by {{ define }} if name isn't empty. Failures have been written to w if
+// ok is false.
+func (s *Server) renderFile(ref FileRef, name string, data map[string]interface{}, w http.ResponseWriter, r *http.Request) (out []byte, ok bool) {
+	ctx, cancel := s.renderContext(r)
+	defer cancel()
+	info := AccessInfoOf(r)
+	var timing *Timing
+	if s.ServerTiming || info != nil {
+		timing = &Timing{}
+	}
+	if s.ServerTiming {
+		defer func() { w.Header().Add("Server-Timing", timing.String()) }()
+	}
+
+	// get template
+	tpl, err := getTemplateTimed(s.Repo, ref, true, timing)
+	info.SetCommit(ref.CommitHash)
+	if timing != nil && timing.cached != nil {
+		info.SetCache(*timing.cached)
+	}
+	switch err {
+	case nil:
+	case ErrCommitNotFound, ErrFileNotFound:
+		s.checkFailure(err, http.StatusNotFound, w)
+		return
+	case ErrFileForbidden:
+		s.checkFailure(err, http.StatusForbidden, w)
+		return
+	case ErrMissSyncTimeout:
+		s.checkFailure(err, http.StatusGatewayTimeout, w)
+		return
+	default:
+		if _, failed := err.(ErrParseFailed); failed && s.PassthroughOnParseError && s.Sources != nil {
+			return s.passthrough(ref, err, w)
+		}
+		log.Print("failed to get template: " + err.Error())
+		if _, failed := err.(ErrSyncFailed); failed {
+			s.checkFailure(err, http.StatusBadGateway, w)
+			return
+		}
+		if _, failed := err.(ErrCacheFailed); failed {
+			s.checkFailure(err, http.StatusServiceUnavailable, w)
+			return
+		}
+		s.checkFailure(err, http.StatusInternalServerError, w)
+		return
+	}
+	if len(name) > 0 {
+		if tpl = tpl.Lookup(name); tpl == nil {
+			s.checkFailure(fmt.Errorf("template %q is not defined in %s", name, effectivePath(ref.FilePath)), http.StatusNotFound, w)
+			return
+		}
+	} else {
+		layout, err := s.useLayout(ref, r)
+		if s.checkFailure(err, http.StatusBadRequest, w) {
+			return
+		}
+		if layout {
+			if tpl, err = s.withLayout(ref, tpl); s.checkFailure(err, http.StatusInternalServerError, w) {
+				return
+			}
+		}
+	}
+
+	// validate data
+	if s.ValidateData && s.Sources != nil {
+		invalid, err := s.validateData(ref, data)
+		if s.checkFailure(err, http.StatusInternalServerError, w) || s.checkFailure(invalid, http.StatusBadRequest, w) {
+			return
+		}
+	}
+
+	// render template
+	now, err := renderTime(data)
+	if s.checkFailure(err, http.StatusBadRequest, w) {
+		return
+	}
+	start := time.Now()
+	out, err = renderRef(ctx, s.Repo, ref, tpl, data, newRand(data), now, nil)
+	timing.Since("render", start)
+	if err != nil && ctx.Err() == context.DeadlineExceeded {
+		s.checkFailure(fmt.Errorf("%v: %v", ErrRenderTimeout, err), http.StatusGatewayTimeout, w)
+		return
+	}
+	if err != nil && strings.Contains(err.Error(), "map has no entry for key") {
+		s.checkFailure(err, http.StatusBadRequest, w)
+		return
+	}
+	if s.checkFailure(err, http.StatusInternalServerError, w) {
+		return
+	}
+	// a template asking for a redirect or a status has told what it meant
+	if len(out) == 0 && s.ErrorOnEmpty && !renderControlOf(ctx).set() {
+		s.checkFailure(ErrEmptyOutput, http.StatusUnprocessableEntity, w)
+		return
+	}
+	return out, true
+}
+
+// setDisposition marks the response as an attachment when asked to by the
+// `download` or `as` query param, the file name defaults to the base name of
+// the requested file.
+func setDisposition(w http.ResponseWriter, r *http.Request, ref FileRef) {
+	name := r.FormValue("as")
+	if len(name) == 0 {
+		if download, _ := strconv.ParseBool(r.FormValue("download")); !download {
+			return
+		}
+		name = path.Base(ref.FilePath)
+	}
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+		"filename": sanitizeFilename(name),
+	}))
+}
+
+// sanitizeFilename replaces anything but letters, digits, '.', '-' and '_' so
+// the name is safe to put into a header.
+func sanitizeFilename(name string) string {
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-', r == '_':
+			return r
+		default:
+			return '_'
+		}
+	}, path.Base(name))
+	if strings.Trim(name, ".") == "" {
+		return "download"
+	}
+	return name
+}
+
+func checkFailure(err error, status int, w http.ResponseWriter) bool {
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), status)
+		return true
+	}
+	return false
+}
+
+// checkFailure is like the package level one, except that it renders the
+// error page of the status if there is one. A failureWriter records the
+// failure instead.
+func (s *Server) checkFailure(err error, status int, w http.ResponseWriter) bool {
+	if err != nil {
+		log.Println(err)
+		if fw, ok := w.(*failureWriter); ok {
+			fw.err, fw.status = err, status
+			return true
+		}
+		if !s.ErrorPages.Render(w, err, status) {
+			http.Error(w, err.Error(), status)
+		}
+		return true
+	}
+	return false
+}
+
+func (s *Server) parseData(r *http.Request) (map[string]interface{}, error) {
+	if err := decodeBody(r, s.maxBodyBytes()); err != nil {
+		return nil, err
+	}
+	err := r.ParseForm()
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]string)
+	for key := range r.Form {
+		value := r.FormValue(key)
+		if s.CaseInsensitiveKeys {
+			key = strings.ToLower(key)
+			if prev, ok := values[key]; ok && prev != value {
+				return nil, fmt.Errorf("conflicting values for case-insensitive key %q", key)
+			}
+		}
+		if isReserved(key) {
+			return nil, fmt.Errorf("%q is a reserved key", key)
+		}
+		values[key] = value
+	}
+	if len(s.HeaderDataPrefix) > 0 {
+		for name := range r.Header {
+			key, ok := headerKey(s.HeaderDataPrefix, name)
+			if !ok {
+				continue
+			}
+			if isReserved(key) {
+				return nil, fmt.Errorf("%q is a reserved key", key)
+			}
+			if _, ok := values[key]; ok && !s.HeaderDataOverride {
+				continue
+			}
+			values[key] = r.Header.Get(name)
+		}
+	}
+	if len(s.PathVars) > 0 {
+		vars := mux.Vars(r)
+		for name, key := range s.PathVars {
+			value, ok := vars[name]
+			if !ok {
+				continue
+			}
+			if isReserved(key) {
+				return nil, fmt.Errorf("%q is a reserved key", key)
+			}
+			values[key] = value
+		}
+	}
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	data := make(map[string]interface{}, len(values)+1)
+	for _, key := range keys {
+		var value interface{} = values[key]
+		if s.CoerceData {
+			value = coerce(values[key])
+		}
+		if err := setNested(data, key, value); err != nil {
+			return nil, err
+		}
+	}
+	data[RequestKey] = s.requestData(r)
+	return data, nil
+}
+
+// setNested stores value under the dotted key, e.g. user.name is stored as
+// data["user"]["name"] so templates can refer to it as .user.name. A key used
+// both as a value and as the parent of other keys is an error.
+func setNested(data map[string]interface{}, key string, value interface{}) error {
+	segments := strings.Split(key, ".")
+	for _, seg := range segments {
+		if len(seg) == 0 {
+			return fmt.Errorf("invalid key %q, it has an empty segment", key)
+		}
+	}
+	m := data
+	for i, seg := range segments[:len(segments)-1] {
+		child, ok := m[seg]
+		if !ok {
+			child = make(map[string]interface{})
+			m[seg] = child
+		}
+		parent, ok := child.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("key %q is used both as a value and as the parent of %q", strings.Join(segments[:i+1], "."), key)
+		}
+		m = parent
+	}
+	last := segments[len(segments)-1]
+	if _, ok := m[last]; ok {
+		return fmt.Errorf("key %q is used both as a value and as the parent of other keys", key)
+	}
+	m[last] = value
+	return nil
+}
+
+// isReserved tells whether the key, or the top level one of a dotted key, is
+// reserved, see RequestKey and AllKey.
+func isReserved(key string) bool {
+	top := strings.SplitN(key, ".", 2)[0]
+	return top == RequestKey || top == AllKey
+}
+
+// headerKey gives the data key of the header with the prefix, the rest of
+// its name is lowercased and dashes become underscores so the key can be used
+// in templates, e.g. X-Tmpl-Who is .who and X-Tmpl-User-Name is .user_name.
+func headerKey(prefix, name string) (string, bool) {
+	if len(name) <= len(prefix) || !strings.EqualFold(name[:len(prefix)], prefix) {
+		return "", false
+	}
+	return strings.Replace(strings.ToLower(name[len(prefix):]), "-", "_", -1), true
+}
+
+// RequestKey is the reserved data key under which templates find the host
+// and the scheme of the request, as well as the headers in ExposeHeaders,
+// e.g. {{ ._request.scheme }}://{{ ._request.host }} or
+// {{ index ._request.headers "X-Request-Id" }}. It's prefixed like AllKey so
+// it doesn't take a key queries may use.
+const RequestKey = "_request"
+
+// AllKey is the reserved data key holding all the query data, so templates
+// can {{ range $k, $v := ._all }} without knowing the keys. It's set by render
+// and doesn't hold the request.
+const AllKey = "_all"
+
+// withAll returns a copy of data with AllKey set, or data itself if it's
+// already set, e.g. when rendering an include.
+func withAll(data map[string]interface{}) map[string]interface{} {
+	if data == nil {
+		return nil
+	}
+	if _, ok := data[AllKey]; ok {
+		return data
+	}
+	all := make(map[string]interface{}, len(data))
+	for k, v := range data {
+		if k != RequestKey {
+			all[k] = v
+		}
+	}
+	copied := make(map[string]interface{}, len(data)+1)
+	for k, v := range data {
+		copied[k] = v
+	}
+	copied[AllKey] = all
+	return copied
+}
+
+func (s *Server) requestData(r *http.Request) map[string]interface{} {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	headers := make(map[string]string, len(s.ExposeHeaders))
+	for _, name := range s.ExposeHeaders {
+		name = http.CanonicalHeaderKey(name)
+		headers[name] = r.Header.Get(name)
+	}
+	return map[string]interface{}{
+		"host":    r.Host,
+		"scheme":  scheme,
+		"headers": headers,
+	}
+}
+
+// floatPattern matches plain decimal numbers like "3.14" or "-0.5".
+var floatPattern = regexp.MustCompile(`^-?(0|[1-9][0-9]*)\.[0-9]+$`)
+
+// coerce converts a value to int64, float64 or bool when it is unambiguous:
+// integers without leading zeros or a plus sign ("42", "-1"), plain decimals
+// ("3.14") and the exact words "true" and "false". Anything else, e.g. "007",
+// "+1", "1e3", "NaN" or "TRUE", stays a string.
+func coerce(s string) interface{} {
+	if i, err := strconv.ParseInt(s, 10, 64); err == nil && strconv.FormatInt(i, 10) == s {
+		return i
+	}
+	if floatPattern.MatchString(s) {
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return f
+		}
+	}
+	if s == "true" || s == "false" {
+		return s == "true"
+	}
+	return s
+}
+
+// render executes the template, a panic during the execution is logged with
+// its stack and returned as an error instead of crashing the server. The error
+// leaves the panic value out, it may tell about the internals.
+func render(tpl *template.Template, data map[string]interface{}) (out []byte, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			log.Printf("panic rendering %s: %v\n%s", tpl.Name(), p, debug.Stack())
+			out, err = nil, fmt.Errorf("panic rendering %s", tpl.Name())
+		}
+	}()
+	var buf bytes.Buffer
+	err = tpl.Execute(&buf, withAll(data))
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
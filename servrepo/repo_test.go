@@ -0,0 +1,1029 @@
+package servrepo
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	htmltemplate "html/template"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"text/template"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+
+	"srcd.works/go-git.v4"
+)
+
+func repo(t testing.TB, repoPath string, cacheSize int) TmplRepo {
+	local, err := git.PlainOpen(repoPath)
+	if err != nil {
+		t.Fatal("failed to open local git repo: " + err.Error())
+	}
+	var repo TmplRepo
+	repo = &GitTmplRepo{Repository: local}
+	if cacheSize > 0 {
+		repo, err = NewCachedTmplRepo(repo, cacheSize)
+		if err != nil {
+			t.Fatal("failed to create cached repo: " + err.Error())
+		}
+	}
+	return repo
+}
+
+// memRepo is an in-memory TmplRepo keyed by FileRef.String().
+type memRepo map[string]string
+
+func (m memRepo) GetTemplate(ref FileRef, sync bool) (*template.Template, error) {
+	src, ok := m[ref.String()]
+	if !ok {
+		return nil, ErrFileNotFound
+	}
+	tpl, err := template.New(ref.String()).Funcs(funcs).Parse(src)
+	if err != nil {
+		return nil, ErrParseFailed{Err: err}
+	}
+	return tpl.Option("missingkey=error"), nil
+}
+
+func (m memRepo) Sync() error { return nil }
+
+func (m memRepo) ReadSource(ref FileRef) ([]byte, error) {
+	src, ok := m[ref.String()]
+	if !ok {
+		return nil, ErrFileNotFound
+	}
+	return []byte(src), nil
+}
+
+const INIT_COMMIT = "dd2bd7756e32a84ed2f2495087e626d4ed648f3a"
+
+// runGit runs git as user t in dir, the current dir if empty, failing the
+// test if git fails, and returns its trimmed output.
+func runGit(t testing.TB, dir string, args ...string) string {
+	return runGitEnv(t, dir, nil, args...)
+}
+
+// runGitEnv is runGit with env added to the environment of git.
+func runGitEnv(t testing.TB, dir string, env []string, args ...string) string {
+	opts := []string{"-c", "user.name=t", "-c", "user.email=t@t", "-c", "protocol.file.allow=always"}
+	if len(dir) > 0 {
+		opts = append(opts, "-C", dir)
+	}
+	cmd := exec.Command("git", append(opts, args...)...)
+	cmd.Env = append(os.Environ(), env...)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %v: %v: %s", args, err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+func server(repo TmplRepo, opts ...func(s *Server)) *httptest.Server {
+	srv := NewServer(repo)
+	for _, opt := range opts {
+		opt(srv)
+	}
+	return httptest.NewServer(srv.Handler())
+}
+
+func TestFindFileFailure(t *testing.T) {
+	r := repo(t, "..", 32)
+	var ref FileRef
+	_, err := r.GetTemplate(ref, false)
+	assert.Equal(t, ErrCommitNotFound, err)
+
+	ref.CommitHash = INIT_COMMIT
+	_, err = r.GetTemplate(ref, false)
+	assert.Equal(t, ErrFileNotFound, err)
+}
+
+func TestHandleFailure(t *testing.T) {
+	s := server(localOnly{repo(t, "..", 32)})
+	defer s.Close()
+
+	url := s.URL + "/raw/0000000000000000000000000000000000000000/templates/hi.txt?who=world"
+	resp, err := http.Get(url)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	url = s.URL + "/md5/0000000000000000000000000000000000000000/templates/hi.txt?who=world"
+	resp, err = http.Get(url)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	url = s.URL + "/raw/" + INIT_COMMIT + "/templates/hi.txt?oops=world"
+	resp, err = http.Get(url)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	url = s.URL + "/md5/" + INIT_COMMIT + "/templates/hi.txt?oops=world"
+	resp, err = http.Get(url)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestRawHandler(t *testing.T) {
+	s := server(repo(t, "..", 32))
+	defer s.Close()
+
+	url := s.URL + "/raw/" + INIT_COMMIT + "/templates/hi.txt?who=world"
+
+	resp, err := http.Get(url)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	assert.Equal(t, "text/plain; charset=utf-8", resp.Header.Get("Content-Type"))
+
+	body, err := ioutil.ReadAll(resp.Body)
+	assert.NoError(t, err, "body should be read")
+	assert.Equal(t, "Hi, world!\n", string(body))
+}
+
+func TestExtractRefFromVars(t *testing.T) {
+	r := mux.NewRouter()
+	r.Path("/t/{file:.*}/at/{ref}").HandlerFunc(RawHandler(repo(t, "..", 32), ExtractRefFromVars("ref", "file")))
+	r.Path("/bad/{ref}").HandlerFunc(RawHandler(repo(t, "..", 32), ExtractRefFromVars("ref", "file")))
+	s := httptest.NewServer(r)
+	defer s.Close()
+
+	resp, err := http.Get(s.URL + "/t/templates/hi.txt/at/" + INIT_COMMIT + "?who=world")
+	assert.NoError(t, err)
+	body, _ := ioutil.ReadAll(resp.Body)
+	assert.Equal(t, "Hi, world!\n", string(body))
+
+	resp, err = http.Get(s.URL + "/bad/" + INIT_COMMIT)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestExtractRefFromHeaders(t *testing.T) {
+	s := httptest.NewServer(RawHandler(repo(t, "..", 32), ExtractRefFromHeaders))
+	defer s.Close()
+	get := func(commit, path string) (int, string) {
+		req, _ := http.NewRequest("GET", s.URL+"/?who=world", nil)
+		req.Header.Set("X-Commit", commit)
+		req.Header.Set("X-Path", path)
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		body, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	status, body := get(strings.ToUpper(INIT_COMMIT), "/templates/hi.txt")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "Hi, world!\n", body)
+	status, body = get(INIT_COMMIT[:7], "templates/hi.txt")
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Contains(t, body, "invalid X-Commit")
+	status, _ = get(INIT_COMMIT, "")
+	assert.Equal(t, http.StatusBadRequest, status)
+}
+
+func TestPathVars(t *testing.T) {
+	srv := NewServer(repo(t, "..", 32))
+	srv.PathVars = map[string]string{"name": "who"}
+	r := mux.NewRouter()
+	r.Path("/greet/" + HashPattern + "/{name}").HandlerFunc(srv.RawHandler(ExtractRefWithPath("hash", "templates/hi.txt")))
+	s := httptest.NewServer(r)
+	defer s.Close()
+
+	for q, expect := range map[string]string{"": "Hi, world!\n", "?who=there": "Hi, world!\n"} {
+		resp, err := http.Get(s.URL + "/greet/" + INIT_COMMIT + "/world" + q)
+		assert.NoError(t, err)
+		body, _ := ioutil.ReadAll(resp.Body)
+		assert.Equal(t, expect, string(body), q)
+	}
+}
+
+func TestNestedPaths(t *testing.T) {
+	srv := NewServer(memRepo{INIT_COMMIT + "::a/b/c/d.txt": "deep {{ .who }}"})
+	r := mux.NewRouter()
+	srv.Register(r.PathPrefix("/base").Subrouter())
+	s := httptest.NewServer(r)
+	defer s.Close()
+
+	// encoded slashes are decoded before matching
+	for path, expect := range map[string]string{
+		"a/b/c/d.txt":   "deep world",
+		"a/b%2Fc/d.txt": "deep world",
+	} {
+		resp, err := http.Get(s.URL + "/base/raw/" + INIT_COMMIT + "/" + path + "?who=world")
+		assert.NoError(t, err)
+		body, _ := ioutil.ReadAll(resp.Body)
+		assert.Equal(t, expect, string(body), path)
+	}
+}
+
+func TestMD5Handler(t *testing.T) {
+	s := server(repo(t, "..", 32))
+	defer s.Close()
+
+	url := s.URL + "/md5/" + INIT_COMMIT + "/templates/hi.txt?who=world"
+
+	resp, err := http.Get(url)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	body, err := ioutil.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, "07197f7673c0074a7e0a64839ba45dd5  hi.txt\n", string(body))
+}
+
+func TestMD5HandlerOfSource(t *testing.T) {
+	gitRepo := repo(t, "..", 0).(*GitTmplRepo)
+	s := server(gitRepo, func(s *Server) { s.Sources = gitRepo })
+	defer s.Close()
+	src, err := ioutil.ReadFile("../templates/hi.txt")
+	assert.NoError(t, err)
+	sum := md5.Sum(src)
+
+	url := s.URL + "/md5/" + INIT_COMMIT + "/templates/hi.txt"
+	for q, expect := range map[string]string{
+		"?of=source":            hex.EncodeToString(sum[:]) + "  hi.txt\n",
+		"?of=source&who=anyone": hex.EncodeToString(sum[:]) + "  hi.txt\n",
+		"?of=render&who=world":  "07197f7673c0074a7e0a64839ba45dd5  hi.txt\n",
+	} {
+		resp, err := http.Get(url + q)
+		assert.NoError(t, err)
+		body, _ := ioutil.ReadAll(resp.Body)
+		assert.Equal(t, expect, string(body), q)
+	}
+
+	resp, err := http.Get(url + "?of=nope")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestCoerce(t *testing.T) {
+	for in, out := range map[string]interface{}{
+		"42":    int64(42),
+		"-1":    int64(-1),
+		"0":     int64(0),
+		"3.14":  3.14,
+		"-0.5":  -0.5,
+		"true":  true,
+		"false": false,
+		"007":   "007",
+		"+1":    "+1",
+		"-0":    "-0",
+		"1.":    "1.",
+		".5":    ".5",
+		"1e3":   "1e3",
+		"NaN":   "NaN",
+		"TRUE":  "TRUE",
+		" 1":    " 1",
+		"":      "",
+	} {
+		assert.Equal(t, out, coerce(in), in)
+	}
+}
+
+func TestCoerceData(t *testing.T) {
+	s := server(memRepo{INIT_COMMIT + "::count.txt": "{{ if gt .count 5 }}many{{ else }}few{{ end }}"},
+		func(s *Server) { s.CoerceData = true })
+	defer s.Close()
+
+	for count, expect := range map[string]string{"3": "few", "10": "many"} {
+		resp, err := http.Get(s.URL + "/raw/" + INIT_COMMIT + "/count.txt?count=" + count)
+		assert.NoError(t, err)
+		body, _ := ioutil.ReadAll(resp.Body)
+		assert.Equal(t, expect, string(body))
+	}
+}
+
+func TestRequestData(t *testing.T) {
+	s := server(memRepo{
+		INIT_COMMIT + "::link.txt": `{{ ._request.scheme }}://{{ ._request.host }}/{{ .who }}`,
+		INIT_COMMIT + "::hdrs.txt": `{{ index ._request.headers "X-Request-Id" }}|{{ index ._request.headers "Authorization" }}`,
+	}, func(s *Server) { s.ExposeHeaders = []string{"x-request-id"} })
+	defer s.Close()
+
+	resp, err := http.Get(s.URL + "/raw/" + INIT_COMMIT + "/link.txt?who=world")
+	assert.NoError(t, err)
+	body, _ := ioutil.ReadAll(resp.Body)
+	assert.Equal(t, s.URL+"/world", string(body))
+
+	req, _ := http.NewRequest("GET", s.URL+"/raw/"+INIT_COMMIT+"/hdrs.txt", nil)
+	req.Header.Set("X-Request-Id", "42")
+	req.Header.Set("Authorization", "secret")
+	resp, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	body, _ = ioutil.ReadAll(resp.Body)
+	assert.Equal(t, "42|", string(body))
+
+	resp, err = http.Get(s.URL + "/raw/" + INIT_COMMIT + "/link.txt?who=world&_request=x")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	// a plain request key is data like any other
+	s2 := server(memRepo{INIT_COMMIT + "::q.txt": `{{ .request }}`})
+	defer s2.Close()
+	resp, err = http.Get(s2.URL + "/raw/" + INIT_COMMIT + "/q.txt?request=x")
+	assert.NoError(t, err)
+	body, _ = ioutil.ReadAll(resp.Body)
+	assert.Equal(t, "x", string(body))
+}
+
+func TestHeaderData(t *testing.T) {
+	repo := memRepo{INIT_COMMIT + "::hi.txt": `{{ .who }}|{{ .user_name }}`}
+	get := func(s *httptest.Server, query string, header map[string]string) (int, string) {
+		req, _ := http.NewRequest("GET", s.URL+"/raw/"+INIT_COMMIT+"/hi.txt"+query, nil)
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		body, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+	hdrs := map[string]string{"X-Tmpl-Who": "world", "x-tmpl-user-name": "bob"}
+
+	s := server(repo)
+	code, body := get(s, "", hdrs)
+	assert.NotEqual(t, http.StatusOK, code, body)
+	s.Close()
+
+	s = server(repo, func(s *Server) { s.HeaderDataPrefix = "X-Tmpl-" })
+	_, body = get(s, "", hdrs)
+	assert.Equal(t, "world|bob", body)
+	_, body = get(s, "?who=there", hdrs)
+	assert.Equal(t, "there|bob", body)
+	code, _ = get(s, "", map[string]string{"X-Tmpl-Request": "x"})
+	assert.Equal(t, http.StatusBadRequest, code)
+	s.Close()
+
+	s = server(repo, func(s *Server) { s.HeaderDataPrefix = "X-Tmpl-"; s.HeaderDataOverride = true })
+	defer s.Close()
+	_, body = get(s, "?who=there", hdrs)
+	assert.Equal(t, "world|bob", body)
+}
+
+func TestAllData(t *testing.T) {
+	s := server(memRepo{
+		INIT_COMMIT + "::all.txt": `{{ range $k, $v := ._all }}{{ $k }}={{ $v }};{{ end }}{{ .all }}`,
+	})
+	defer s.Close()
+
+	resp, err := http.Get(s.URL + "/raw/" + INIT_COMMIT + "/all.txt?b=2&a=1&all=x")
+	assert.NoError(t, err)
+	body, _ := ioutil.ReadAll(resp.Body)
+	assert.Equal(t, "a=1;all=x;b=2;x", string(body))
+
+	resp, err = http.Get(s.URL + "/raw/" + INIT_COMMIT + "/all.txt?_all=x")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestMissingKey(t *testing.T) {
+	local, err := git.PlainOpen("..")
+	assert.NoError(t, err)
+	for missingKey, expect := range map[string]string{
+		"":        "",
+		"error":   "",
+		"zero":    "Hi, <no value>!\n",
+		"default": "Hi, <no value>!\n",
+	} {
+		s := server(&GitTmplRepo{Repository: local, MissingKey: missingKey})
+		resp, err := http.Get(s.URL + "/raw/" + INIT_COMMIT + "/templates/hi.txt")
+		assert.NoError(t, err)
+		body, _ := ioutil.ReadAll(resp.Body)
+		if len(expect) == 0 {
+			assert.Equal(t, http.StatusBadRequest, resp.StatusCode, missingKey)
+		} else {
+			assert.Equal(t, http.StatusOK, resp.StatusCode, missingKey)
+			assert.Equal(t, expect, string(body), missingKey)
+		}
+		s.Close()
+	}
+
+	assert.NoError(t, CheckMissingKey("zero"))
+	assert.Error(t, CheckMissingKey("nil"))
+}
+
+func TestNestedData(t *testing.T) {
+	s := server(memRepo{
+		INIT_COMMIT + "::user.txt": `{{ .user.name }} ({{ .user.age }})`,
+		INIT_COMMIT + "::deep.txt": `{{ .a.b.c }}|{{ .a.b.d }}|{{ .a.e }}`,
+	})
+	defer s.Close()
+	get := func(path string) (int, string) {
+		resp, err := http.Get(s.URL + "/raw/" + INIT_COMMIT + "/" + path)
+		assert.NoError(t, err)
+		body, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	_, body := get("user.txt?user.name=alice&user.age=30")
+	assert.Equal(t, "alice (30)", body)
+	_, body = get("deep.txt?a.b.c=1&a.b.d=2&a.e=3")
+	assert.Equal(t, "1|2|3", body)
+
+	for _, q := range []string{"?user=bob&user.name=alice", "?a.b=1&a.b.c=2", "?user..name=x", "?request.x=1"} {
+		code, body := get("user.txt" + q)
+		assert.Equal(t, http.StatusBadRequest, code, q)
+		assert.NotEmpty(t, body, q)
+	}
+}
+
+func TestSetNested(t *testing.T) {
+	data := make(map[string]interface{})
+	assert.NoError(t, setNested(data, "a.b.c", 1))
+	assert.NoError(t, setNested(data, "a.b.d", 2))
+	assert.NoError(t, setNested(data, "x", "y"))
+	assert.Equal(t, map[string]interface{}{
+		"a": map[string]interface{}{"b": map[string]interface{}{"c": 1, "d": 2}},
+		"x": "y",
+	}, data)
+
+	assert.EqualError(t, setNested(data, "x.y", 1), `key "x" is used both as a value and as the parent of "x.y"`)
+	assert.EqualError(t, setNested(data, "a.b", 1), `key "a.b" is used both as a value and as the parent of other keys`)
+	assert.EqualError(t, setNested(data, "a.", 1), `invalid key "a.", it has an empty segment`)
+}
+
+func TestNamedTemplate(t *testing.T) {
+	s := server(memRepo{
+		INIT_COMMIT + "::frags.txt": `{{ define "a" }}A {{ .who }}{{ end }}{{ define "b" }}B {{ template "a" . }}{{ end }}main`,
+	})
+	defer s.Close()
+	url := s.URL + "/raw/" + INIT_COMMIT + "/frags.txt?who=world"
+
+	for q, expect := range map[string]string{"": "main", "&template=a": "A world", "&template=b": "B A world"} {
+		resp, err := http.Get(url + q)
+		assert.NoError(t, err)
+		body, _ := ioutil.ReadAll(resp.Body)
+		assert.Equal(t, expect, string(body), q)
+	}
+
+	resp, err := http.Get(url + "&template=c")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestBlobHash(t *testing.T) {
+	local, err := git.PlainOpen("..")
+	assert.NoError(t, err)
+	gitRepo := &GitTmplRepo{Repository: local}
+	s := server(gitRepo, func(s *Server) { s.Blobs = gitRepo })
+	defer s.Close()
+
+	for _, route := range []string{"/raw/", "/md5/"} {
+		resp, err := http.Get(s.URL + route + INIT_COMMIT + "/templates/hi.txt?who=world")
+		assert.NoError(t, err)
+		assert.Equal(t, "c840f36ca9ac69e78ca19fb7abf681aa45d7e064", resp.Header.Get("X-Blob-Hash"), route)
+	}
+
+	_, err = gitRepo.BlobHash(FileRef{CommitHash: INIT_COMMIT, FilePath: "templates/nope.txt"})
+	assert.Equal(t, ErrFileNotFound, err)
+}
+
+func TestCaseInsensitiveKeys(t *testing.T) {
+	s := server(repo(t, "..", 32), func(s *Server) { s.CaseInsensitiveKeys = true })
+	defer s.Close()
+	url := s.URL + "/raw/" + INIT_COMMIT + "/templates/hi.txt"
+
+	for _, q := range []string{"?Who=world", "?WHO=world&who=world"} {
+		resp, err := http.Get(url + q)
+		assert.NoError(t, err)
+		body, _ := ioutil.ReadAll(resp.Body)
+		assert.Equal(t, "Hi, world!\n", string(body), q)
+	}
+
+	resp, err := http.Get(url + "?Who=world&who=there")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+// panickingRepo panics getting the templates of paths starting with boom.
+type panickingRepo struct{ memRepo }
+
+func (r panickingRepo) GetTemplate(ref FileRef, sync bool) (*template.Template, error) {
+	if strings.HasPrefix(ref.FilePath, "boom") {
+		panic("boom: secret internals")
+	}
+	return r.memRepo.GetTemplate(ref, sync)
+}
+
+func TestRenderPanic(t *testing.T) {
+	s := server(panickingRepo{memRepo{INIT_COMMIT + "::ok.txt": "ok"}})
+	defer s.Close()
+
+	resp, err := http.Get(s.URL + "/raw/" + INIT_COMMIT + "/boom.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+	body, _ := ioutil.ReadAll(resp.Body)
+	assert.Equal(t, ErrInternal.Error()+"\n", string(body))
+
+	resp, err = http.Get(s.URL + "/raw/" + INIT_COMMIT + "/ok.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestDecodeSource(t *testing.T) {
+	raw, err := decodeSource("a.tmpl", []byte("plain"))
+	assert.NoError(t, err)
+	assert.Equal(t, "plain", string(raw))
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write([]byte("Hi, {{ .who }}!"))
+	zw.Close()
+	raw, err = decodeSource("a.tmpl.gz", buf.Bytes())
+	assert.NoError(t, err)
+	assert.Equal(t, "Hi, {{ .who }}!", string(raw))
+
+	_, err = decodeSource("a.tmpl.gz", []byte("not gzipped"))
+	assert.Error(t, err)
+
+	assert.Equal(t, "conf/app.json", effectivePath("conf/app.json.gz"))
+}
+
+func TestUppercaseHash(t *testing.T) {
+	s := server(repo(t, "..", 32))
+	defer s.Close()
+
+	resp, err := http.Get(s.URL + "/raw/" + strings.ToUpper(INIT_COMMIT) + "/templates/hi.txt?who=world")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	body, _ := ioutil.ReadAll(resp.Body)
+	assert.Equal(t, "Hi, world!\n", string(body))
+}
+
+func TestRawHandlerDownload(t *testing.T) {
+	s := server(repo(t, "..", 32))
+	defer s.Close()
+
+	url := s.URL + "/raw/" + INIT_COMMIT + "/templates/hi.txt?who=world"
+	resp, err := http.Get(url)
+	assert.NoError(t, err)
+	assert.Empty(t, resp.Header.Get("Content-Disposition"))
+
+	resp, err = http.Get(url + "&download=true")
+	assert.NoError(t, err)
+	assert.Equal(t, "attachment; filename=hi.txt", resp.Header.Get("Content-Disposition"))
+
+	resp, err = http.Get(url + "&as=" + "greet%0D%0AX-Evil:%20yes.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, "attachment; filename=greet__X-Evil__yes.txt", resp.Header.Get("Content-Disposition"))
+	assert.Empty(t, resp.Header.Get("X-Evil"))
+}
+
+func TestRawHandlerMultiPaths(t *testing.T) {
+	s := server(memRepo{
+		INIT_COMMIT + "::a.txt":   "A {{ .who }}",
+		INIT_COMMIT + "::b/c.txt": "C {{ .who }}",
+	})
+	defer s.Close()
+	url := s.URL + "/raw/" + INIT_COMMIT + "/"
+
+	resp, err := http.Get(url + "a.txt,b/c.txt?who=world")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+	var outs map[string]string
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&outs))
+	assert.Equal(t, map[string]string{"a.txt": "A world", "b/c.txt": "C world"}, outs)
+
+	// a trailing comma is just a single path
+	resp, err = http.Get(url + "a.txt,?who=world")
+	assert.NoError(t, err)
+	body, _ := ioutil.ReadAll(resp.Body)
+	assert.Equal(t, "A world", string(body))
+
+	resp, err = http.Get(url + "a.txt,nope.txt?who=world")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp, err = http.Get(url + strings.Repeat("a.txt,", MaxMultiPaths+1) + "?who=world")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestRawHandlerStream(t *testing.T) {
+	s := server(memRepo{
+		INIT_COMMIT + "::a.txt":   "A {{ .who }}",
+		INIT_COMMIT + "::b/c.txt": "C {{ .who }}",
+	})
+	defer s.Close()
+	url := s.URL + "/raw/" + INIT_COMMIT + "/"
+
+	resp, err := http.Get(url + "a.txt,nope.txt,b/c.txt?who=world&stream=ndjson")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "application/x-ndjson", resp.Header.Get("Content-Type"))
+	var items []streamedItem
+	dec := json.NewDecoder(resp.Body)
+	for dec.More() {
+		var item streamedItem
+		assert.NoError(t, dec.Decode(&item))
+		items = append(items, item)
+	}
+	assert.Equal(t, []streamedItem{
+		{Index: 0, Path: "a.txt", Output: "A world"},
+		{Index: 1, Path: "nope.txt", Error: ErrFileNotFound.Error(), Status: http.StatusNotFound},
+		{Index: 2, Path: "b/c.txt", Output: "C world"},
+	}, items)
+
+	resp, err = http.Get(url + "a.txt?stream=ndjson")
+	assert.NoError(t, err)
+	var item streamedItem
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&item))
+	assert.Equal(t, http.StatusBadRequest, item.Status)
+	assert.Contains(t, item.Error, "map has no entry for key")
+
+	resp, err = http.Get(url + "a.txt?who=world&stream=xml")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestErrorPages(t *testing.T) {
+	dir, err := ioutil.TempDir("", "errpages")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "404.html"), []byte("<p>{{ .Status }}: {{ .Error }}</p>"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "400.txt"), []byte("{{ .NoSuchField }}"), 0644)
+
+	pages, err := LoadErrorPages(dir)
+	assert.NoError(t, err)
+	s := server(localOnly{repo(t, "..", 32)}, func(s *Server) { s.ErrorPages = pages })
+	defer s.Close()
+
+	resp, err := http.Get(s.URL + "/raw/0000000000000000000000000000000000000000/templates/hi.txt?who=world")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	assert.Equal(t, "text/html; charset=utf-8", resp.Header.Get("Content-Type"))
+	body, _ := ioutil.ReadAll(resp.Body)
+	assert.Equal(t, "<p>404: "+ErrCommitNotFound.Error()+"</p>", string(body))
+
+	// a broken error page falls back to plain text
+	resp, err = http.Get(s.URL + "/raw/" + INIT_COMMIT + "/templates/hi.txt?oops=world")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Equal(t, "text/plain; charset=utf-8", resp.Header.Get("Content-Type"))
+	body, _ = ioutil.ReadAll(resp.Body)
+	assert.Contains(t, string(body), "map has no entry for key")
+}
+
+// failingRepo fails to get any template with err.
+// localOnly never syncs for a missing commit, so a missing commit is not
+// found whether or not the remote of the fixture can be reached.
+type localOnly struct{ TmplRepo }
+
+func (r localOnly) GetTemplate(ref FileRef, sync bool) (*template.Template, error) {
+	return r.TmplRepo.GetTemplate(ref, false)
+}
+
+type failingRepo struct{ err error }
+
+func (r failingRepo) GetTemplate(ref FileRef, sync bool) (*template.Template, error) {
+	return nil, r.err
+}
+
+func (r failingRepo) Sync() error { return r.err }
+
+func TestErrSyncFailed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "norepo")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	local, err := git.PlainInit(dir, false)
+	assert.NoError(t, err)
+	err = (&GitTmplRepo{Repository: local}).Sync()
+	_, failed := err.(ErrSyncFailed)
+	assert.True(t, failed, "%v", err)
+
+	// a commit still missing after the failed sync reports the sync failure
+	_, err = (&GitTmplRepo{Repository: local}).GetTemplate(FileRef{CommitHash: INIT_COMMIT, FilePath: "hi.txt"}, true)
+	_, failed = err.(ErrSyncFailed)
+	assert.True(t, failed, "%v", err)
+
+	s := server(failingRepo{err})
+	defer s.Close()
+	resp, err := http.Get(s.URL + "/raw/" + INIT_COMMIT + "/hi.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadGateway, resp.StatusCode)
+}
+
+func TestGetTemplateSyncFailure(t *testing.T) {
+	r := repo(t, "..", 0).(*GitTmplRepo)
+	r.Breaker = NewBreaker(1, time.Minute)
+	r.Breaker.Record(false)
+
+	// locally available content wins over a failing sync
+	_, err := r.GetTemplate(FileRef{CommitHash: INIT_COMMIT, FilePath: "templates/hi.txt"}, true)
+	assert.NoError(t, err)
+
+	// a missing commit reports the lookup failure, not the sync failure
+	_, err = r.GetTemplate(FileRef{CommitHash: strings.Repeat("0", 40), FilePath: "templates/hi.txt"}, true)
+	assert.Equal(t, ErrCommitNotFound, err)
+}
+
+func TestCachedTmplRepoInvalidate(t *testing.T) {
+	r := repo(t, "..", 32).(*CachedTmplRepo)
+	ref := FileRef{CommitHash: INIT_COMMIT, FilePath: "templates/hi.txt"}
+	other := FileRef{CommitHash: INIT_COMMIT, FilePath: "templates/other.txt"}
+	r.Cache.Add(other.String(), template.New("other"))
+
+	tpl, err := r.GetTemplate(ref, false)
+	assert.NoError(t, err)
+	assert.True(t, r.Cache.Contains(ref.String()))
+
+	r.Invalidate(ref)
+	assert.False(t, r.Cache.Contains(ref.String()))
+	assert.True(t, r.Cache.Contains(other.String()))
+
+	again, err := r.GetTemplate(ref, false)
+	assert.NoError(t, err)
+	assert.False(t, tpl == again, "template should be loaded again")
+}
+
+func TestCachedTmplRepoNoCache(t *testing.T) {
+	r := repo(t, "..", 32).(*CachedTmplRepo)
+	r.NoCache = []string{"templates/*.txt"}
+	ref := FileRef{CommitHash: INIT_COMMIT, FilePath: "templates/hi.txt"}
+
+	tpl, err := r.GetTemplate(ref, false)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, r.Cache.Len())
+	again, err := r.GetTemplate(ref, false)
+	assert.NoError(t, err)
+	assert.False(t, tpl == again, "template should be loaded again")
+
+	out, err := render(again, map[string]interface{}{"who": "world"})
+	assert.NoError(t, err)
+	assert.Equal(t, "Hi, world!\n", string(out))
+}
+
+func TestCachedTmplRepoEvictions(t *testing.T) {
+	r := repo(t, "..", 1).(*CachedTmplRepo)
+	_, err := r.GetTemplate(FileRef{CommitHash: INIT_COMMIT, FilePath: "templates/hi.txt"}, false)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(0), r.Evictions())
+
+	r.Cache.Add("another", template.New("another"))
+	assert.Equal(t, uint64(1), r.Evictions())
+}
+
+func TestHeadsChanged(t *testing.T) {
+	dir, err := ioutil.TempDir("", "remote")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	origin, clone := filepath.Join(dir, "origin"), filepath.Join(dir, "clone")
+	runGit(t, "", "init", "-q", "-b", "main", origin)
+	runGit(t, origin, "commit", "-q", "--allow-empty", "-m", "first")
+	runGit(t, origin, "tag", "v1")
+	runGit(t, "", "clone", "-q", origin, clone)
+	head := runGit(t, origin, "rev-parse", "HEAD")
+
+	local, err := git.PlainOpen(clone)
+	assert.NoError(t, err)
+	r := &GitTmplRepo{Repository: local}
+	assert.False(t, r.headsChanged(map[string]string{"HEAD": INIT_COMMIT, "refs/heads/main": head, "refs/tags/v1": head}))
+	assert.True(t, r.headsChanged(map[string]string{"refs/heads/main": INIT_COMMIT}))
+	assert.True(t, r.headsChanged(map[string]string{"refs/heads/main": head, "refs/heads/new": head}))
+	assert.True(t, r.headsChanged(map[string]string{"refs/heads/main": head, "refs/tags/v1": INIT_COMMIT}))
+	assert.True(t, r.headsChanged(map[string]string{"refs/heads/main": head, "refs/tags/v2": head}))
+}
+
+func TestAllowExt(t *testing.T) {
+	r := repo(t, "..", 0).(*GitTmplRepo)
+	r.AllowExt = []string{".tmpl", ".TXT"}
+	s := server(r)
+	defer s.Close()
+
+	resp, err := http.Get(s.URL + "/raw/" + INIT_COMMIT + "/templates/hi.txt?who=world")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	r.AllowExt = []string{".tmpl"}
+	resp, err = http.Get(s.URL + "/raw/" + INIT_COMMIT + "/templates/hi.txt?who=world")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	files, err := r.ListFiles(FileRef{CommitHash: INIT_COMMIT})
+	assert.NoError(t, err)
+	assert.Empty(t, files)
+
+	assert.True(t, r.allowed("a/b.tmpl.gz"))
+	assert.False(t, r.allowed("a/b.key.gz"))
+}
+
+func TestResolveRef(t *testing.T) {
+	r := repo(t, "..", 0).(*GitTmplRepo)
+	hash, err := r.ResolveRef(INIT_COMMIT)
+	assert.NoError(t, err)
+	assert.Equal(t, INIT_COMMIT, hash)
+
+	hash, err = r.ResolveRef("HEAD")
+	assert.NoError(t, err)
+	assert.Len(t, hash, 40)
+
+	_, err = r.ResolveRef("no-such-ref")
+	assert.Equal(t, ErrRefNotFound, err)
+}
+
+func TestPinnedTmplRepo(t *testing.T) {
+	resolve := func(ref string) (string, error) { return INIT_COMMIT, nil }
+	pinned, err := NewPinnedTmplRepo(repo(t, "..", 32), resolve, "v1")
+	assert.NoError(t, err)
+	assert.Equal(t, INIT_COMMIT, pinned.Commit())
+
+	r := mux.NewRouter()
+	r.Path("/raw/" + PathPattern).HandlerFunc(RawHandler(pinned, pinned.ExtractRef))
+	s := httptest.NewServer(r)
+	defer s.Close()
+
+	resp, err := http.Get(s.URL + "/raw/templates/hi.txt?who=world")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	body, _ := ioutil.ReadAll(resp.Body)
+	assert.Equal(t, "Hi, world!\n", string(body))
+}
+
+func TestBranchTmplRepo(t *testing.T) {
+	heads := map[string]string{"stable": INIT_COMMIT, "beta": "0000000000000000000000000000000000000000"}
+	resolve := func(ref string) (string, error) { return heads[ref], nil }
+	branches, err := NewBranchTmplRepo(localOnly{repo(t, "..", 32)}, resolve, []string{"stable", "beta"})
+	assert.NoError(t, err)
+
+	r := mux.NewRouter()
+	r.Path("/b/{branch}/raw/" + PathPattern).HandlerFunc(RawHandler(branches, branches.ExtractRef))
+	s := httptest.NewServer(r)
+	defer s.Close()
+
+	get := func(branch string) int {
+		resp, err := http.Get(s.URL + "/b/" + branch + "/raw/templates/hi.txt?who=world")
+		assert.NoError(t, err)
+		return resp.StatusCode
+	}
+	assert.Equal(t, http.StatusOK, get("stable"))
+	assert.Equal(t, http.StatusNotFound, get("beta"))
+	assert.Equal(t, http.StatusNotFound, get("alpha"))
+
+	// the branches follow their heads once resolved again
+	heads["stable"], heads["beta"] = heads["beta"], heads["stable"]
+	assert.NoError(t, branches.Resolve())
+	assert.Equal(t, http.StatusNotFound, get("stable"))
+	assert.Equal(t, http.StatusOK, get("beta"))
+}
+
+func TestRefTTL(t *testing.T) {
+	other := strings.Repeat("1", 40)
+	heads := map[string]string{"v1": INIT_COMMIT, "stable": INIT_COMMIT}
+	resolve := func(ref string) (string, error) { return heads[ref], nil }
+	pinned, err := NewPinnedTmplRepo(memRepo{}, resolve, "v1")
+	assert.NoError(t, err)
+	branches, err := NewBranchTmplRepo(memRepo{}, resolve, []string{"stable"})
+	assert.NoError(t, err)
+	pinned.TTL, branches.TTL = 20*time.Millisecond, 20*time.Millisecond
+	req := mux.SetURLVars(httptest.NewRequest("GET", "/", nil), map[string]string{"branch": "stable", "path": "a.txt"})
+	commits := func() (string, string) {
+		p, err := pinned.ExtractRef(req)
+		assert.NoError(t, err)
+		b, err := branches.ExtractRef(req)
+		assert.NoError(t, err)
+		return p.CommitHash, b.CommitHash
+	}
+
+	heads["v1"], heads["stable"] = other, other
+	p, b := commits()
+	assert.Equal(t, INIT_COMMIT, p)
+	assert.Equal(t, INIT_COMMIT, b)
+	time.Sleep(30 * time.Millisecond)
+	p, b = commits()
+	assert.Equal(t, other, p)
+	assert.Equal(t, other, b)
+}
+
+func BenchmarkTmplRepoWithoutCache(b *testing.B) {
+	s := server(repo(b, "..", 0))
+	defer s.Close()
+
+	benchServer(b, s)
+}
+
+func BenchmarkTmplRepoWithCache(b *testing.B) {
+	s := server(repo(b, "..", 32))
+	defer s.Close()
+
+	benchServer(b, s)
+}
+
+func benchServer(b *testing.B, s *httptest.Server) {
+	url := s.URL + "/raw/" + INIT_COMMIT + "/templates/hi.txt?who=world"
+	for i := 0; i < b.N; i++ {
+		resp, err := http.Get(url)
+		if err != nil {
+			b.Fatal("failed to get response: " + err.Error())
+		}
+		// avoid 'too many open files'
+		resp.Body.Close()
+	}
+}
+
+const benchTmpl = `<ul>{{ range $i, $e := .items }}<li class="{{ $.class }}">{{ $i }}: {{ $e }}</li>{{ end }}</ul>`
+
+var benchData = map[string]interface{}{
+	"class": "item",
+	"items": []string{"a & b", "<c>", "d", "e", "f", "g", "h", "i"},
+}
+
+// BenchmarkRenderText and BenchmarkRenderHTML render the same template with
+// text/template and html/template, to measure the cost of auto-escaping.
+func BenchmarkRenderText(b *testing.B) {
+	tpl, err := memRepo{INIT_COMMIT + "::bench.html": benchTmpl}.GetTemplate(FileRef{INIT_COMMIT, "bench.html"}, false)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := render(tpl, benchData); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkRenderHTML(b *testing.B) {
+	tpl, err := htmltemplate.New("bench.html").Option("missingkey=error").Parse(benchTmpl)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var buf bytes.Buffer
+		if err := tpl.Execute(&buf, benchData); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestPassthroughOnParseError(t *testing.T) {
+	m := memRepo{
+		INIT_COMMIT + "::notes.unknown": "{{ not a template",
+		INIT_COMMIT + "::page.html":     "<p>{{ end }}</p>",
+	}
+	get := func(s *httptest.Server, path string) (int, string, string) {
+		resp, err := http.Get(s.URL + "/raw/" + INIT_COMMIT + "/" + path)
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, resp.Header.Get("Content-Type"), string(body)
+	}
+
+	s := server(m)
+	defer s.Close()
+	status, _, _ := get(s, "notes.unknown")
+	assert.Equal(t, http.StatusInternalServerError, status)
+
+	s2 := server(m, func(s *Server) {
+		s.PassthroughOnParseError = true
+		s.Sources = m
+	})
+	defer s2.Close()
+	status, ctype, body := get(s2, "notes.unknown")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "text/plain; charset=utf-8", ctype)
+	assert.Equal(t, "{{ not a template", body)
+	status, ctype, body = get(s2, "page.html")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "text/html; charset=utf-8", ctype)
+	assert.Equal(t, "<p>{{ end }}</p>", body)
+
+	// the type is set by passthrough itself, not only by /raw
+	srv := NewServer(m)
+	srv.Sources = m
+	w := httptest.NewRecorder()
+	_, ok := srv.passthrough(FileRef{CommitHash: INIT_COMMIT, FilePath: "page.html"}, nil, w)
+	assert.True(t, ok)
+	assert.Equal(t, "text/html; charset=utf-8", w.Header().Get("Content-Type"))
+}
+
+func TestErrorOnEmpty(t *testing.T) {
+	m := memRepo{INIT_COMMIT + "::a.txt": `{{ if .on }}on{{ end }}`}
+	get := func(s *httptest.Server, q string) int {
+		resp, err := http.Get(s.URL + "/raw/" + INIT_COMMIT + "/a.txt" + q)
+		assert.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	s := server(m)
+	defer s.Close()
+	assert.Equal(t, http.StatusOK, get(s, "?on="))
+
+	s2 := server(m, func(s *Server) { s.ErrorOnEmpty = true })
+	defer s2.Close()
+	assert.Equal(t, http.StatusUnprocessableEntity, get(s2, "?on="))
+	assert.Equal(t, http.StatusOK, get(s2, "?on=1"))
+
+	m[INIT_COMMIT+"::a.txt"] = `{{ status 404 }}`
+	assert.Equal(t, http.StatusNotFound, get(s2, ""))
+}
@@ -0,0 +1,187 @@
+package servrepo
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru"
+)
+
+// ResponseCache caches successful GET responses by the full request URL,
+// scheme and host included, for TTL. Output rendered from a commit is deterministic, so identical requests
+// can be answered without rendering again. A response with a Vary header is
+// only reused for requests with the same values of the headers it lists, and
+// one with Cache-Control: no-store isn't cached.
+type ResponseCache struct {
+	TTL   time.Duration
+	Cache *lru.Cache
+	// Refs counts the refs of the responses served from the cache, which
+	// the handlers don't see, so it should be the Server.RefRequests.
+	Refs *RefCounter
+}
+
+type cachedResponse struct {
+	header  http.Header
+	body    []byte
+	etag    string
+	vary    string
+	expires time.Time
+	refs    []FileRef
+}
+
+type seenRefsKey struct{}
+
+// seenRefs collects the refs counted while a response is rendered, so a hit
+// of the cached response counts them again.
+type seenRefs struct {
+	mu   sync.Mutex
+	refs []FileRef
+}
+
+// noteRef records ref as counted for the response to r, if it may be cached.
+func noteRef(r *http.Request, ref FileRef) {
+	if seen, ok := r.Context().Value(seenRefsKey{}).(*seenRefs); ok {
+		seen.mu.Lock()
+		seen.refs = append(seen.refs, ref)
+		seen.mu.Unlock()
+	}
+}
+
+// NewResponseCache returns a cache holding at most size responses.
+func NewResponseCache(size int, ttl time.Duration) (*ResponseCache, error) {
+	cache, err := lru.New(size)
+	if err != nil {
+		return nil, err
+	}
+	return &ResponseCache{TTL: ttl, Cache: cache}, nil
+}
+
+// Handler serves cached responses and caches the 200 responses of handler.
+// Responses carry an ETag, and a matching If-None-Match gets a 304. Requests
+// carrying credentials and the admin routes are never cached, nor are
+// responses flushed while they are written, e.g. streams.
+func (c *ResponseCache) Handler(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || len(r.Header.Get("Authorization")) > 0 || strings.HasPrefix(r.URL.Path, "/_admin/") {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		key := cacheKey(r)
+		if v, ok := c.Cache.Get(key); ok {
+			resp := v.(*cachedResponse)
+			if vary, ok := varyValues(resp.header, r); ok && vary == resp.vary && time.Now().Before(resp.expires) {
+				w.Header().Set("X-Cache", "HIT")
+				AccessInfoOf(r).SetCache(true)
+				for _, ref := range resp.refs {
+					c.Refs.Inc(ref)
+				}
+				resp.write(w, r)
+				return
+			}
+			c.Cache.Remove(key)
+		}
+
+		seen := &seenRefs{}
+		rec := &responseRecorder{w: w, header: make(http.Header), status: http.StatusOK}
+		handler.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), seenRefsKey{}, seen)))
+		if rec.streaming {
+			return
+		}
+		if rec.status != http.StatusOK {
+			copyHeader(w.Header(), rec.header)
+			w.WriteHeader(rec.status)
+			w.Write(rec.body.Bytes())
+			return
+		}
+		sum := md5.Sum(rec.body.Bytes())
+		// the timing is of this very render, a hit doesn't take that long
+		header := make(http.Header, len(rec.header))
+		copyHeader(header, rec.header)
+		header.Del("Server-Timing")
+		resp := &cachedResponse{
+			header:  header,
+			body:    rec.body.Bytes(),
+			etag:    `"` + hex.EncodeToString(sum[:]) + `"`,
+			expires: time.Now().Add(c.TTL),
+			refs:    seen.refs,
+		}
+		if vary, ok := varyValues(rec.header, r); ok && !strings.Contains(rec.header.Get("Cache-Control"), "no-store") {
+			resp.vary = vary
+			c.Cache.Add(key, resp)
+		}
+		w.Header().Set("X-Cache", "MISS")
+		if timing, ok := rec.header["Server-Timing"]; ok {
+			w.Header()["Server-Timing"] = timing
+		}
+		resp.write(w, r)
+	})
+}
+
+// cacheKey is the URL of r with its scheme and host, which templates may read
+// from the request data.
+func cacheKey(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + r.URL.RequestURI()
+}
+
+func (resp *cachedResponse) write(w http.ResponseWriter, r *http.Request) {
+	copyHeader(w.Header(), resp.header)
+	w.Header().Set("ETag", resp.etag)
+	if r.Header.Get("If-None-Match") == resp.etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Write(resp.body)
+}
+
+func copyHeader(dst, src http.Header) {
+	for k, vs := range src {
+		dst[k] = vs
+	}
+}
+
+// responseRecorder records a response to be cached, until it's flushed: the
+// response is then written through to w and not cached.
+type responseRecorder struct {
+	w         http.ResponseWriter
+	header    http.Header
+	status    int
+	body      bytes.Buffer
+	streaming bool
+}
+
+func (r *responseRecorder) Header() http.Header { return r.header }
+
+func (r *responseRecorder) WriteHeader(status int) { r.status = status }
+
+func (r *responseRecorder) Write(p []byte) (int, error) {
+	if r.streaming {
+		return r.w.Write(p)
+	}
+	return r.body.Write(p)
+}
+
+func (r *responseRecorder) Flush() {
+	if !r.streaming {
+		r.streaming = true
+		copyHeader(r.w.Header(), r.header)
+		r.w.WriteHeader(r.status)
+		r.w.Write(r.body.Bytes())
+		r.body.Reset()
+	}
+	if f, ok := r.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets an http.ResponseController reach the underlying writer.
+func (r *responseRecorder) Unwrap() http.ResponseWriter { return r.w }
@@ -0,0 +1,126 @@
+package servrepo
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResponseCache(t *testing.T) {
+	calls := 0
+	c, err := NewResponseCache(2, 50*time.Millisecond)
+	assert.NoError(t, err)
+	h := c.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if r.URL.Query().Get("fail") != "" {
+			http.Error(w, "oops", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Server-Timing", fmt.Sprintf("render;dur=%d", calls))
+		fmt.Fprintf(w, "call %d", calls)
+	}))
+	get := func(url, etag string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", url, nil)
+		if len(etag) > 0 {
+			r.Header.Set("If-None-Match", etag)
+		}
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	w := get("/a?x=1", "")
+	assert.Equal(t, "MISS", w.Header().Get("X-Cache"))
+	assert.Equal(t, "call 1", w.Body.String())
+	assert.Equal(t, "render;dur=1", w.Header().Get("Server-Timing"))
+	etag := w.Header().Get("ETag")
+	assert.NotEmpty(t, etag)
+
+	// the timing of the render isn't replayed
+	w = get("/a?x=1", "")
+	assert.Equal(t, "HIT", w.Header().Get("X-Cache"))
+	assert.Equal(t, "call 1", w.Body.String())
+	assert.Empty(t, w.Header().Get("Server-Timing"))
+	assert.Equal(t, etag, w.Header().Get("ETag"))
+
+	w = get("/a?x=1", etag)
+	assert.Equal(t, http.StatusNotModified, w.Code)
+
+	// the query is part of the key
+	assert.Equal(t, "call 2", get("/a?x=2", "").Body.String())
+
+	// failures aren't cached
+	assert.Equal(t, http.StatusBadRequest, get("/a?fail=1", "").Code)
+	assert.Equal(t, http.StatusBadRequest, get("/a?fail=1", "").Code)
+	assert.Equal(t, 4, calls)
+
+	time.Sleep(60 * time.Millisecond)
+	assert.Equal(t, "call 5", get("/a?x=1", "").Body.String())
+}
+
+func TestResponseCacheHost(t *testing.T) {
+	c, err := NewResponseCache(8, time.Minute)
+	assert.NoError(t, err)
+	h := c.Handler(NewServer(memRepo{INIT_COMMIT + "::at.txt": "{{ ._request.scheme }}://{{ ._request.host }}"}).Handler())
+	get := func(url string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", url, nil))
+		return w
+	}
+
+	w := get("http://a.example/raw/" + INIT_COMMIT + "/at.txt")
+	assert.Equal(t, "MISS", w.Header().Get("X-Cache"))
+	assert.Equal(t, "http://a.example", w.Body.String())
+	w = get("http://b.example/raw/" + INIT_COMMIT + "/at.txt")
+	assert.Equal(t, "MISS", w.Header().Get("X-Cache"))
+	assert.Equal(t, "http://b.example", w.Body.String())
+	w = get("https://a.example/raw/" + INIT_COMMIT + "/at.txt")
+	assert.Equal(t, "MISS", w.Header().Get("X-Cache"))
+	assert.Equal(t, "https://a.example", w.Body.String())
+	w = get("http://a.example/raw/" + INIT_COMMIT + "/at.txt")
+	assert.Equal(t, "HIT", w.Header().Get("X-Cache"))
+	assert.Equal(t, "http://a.example", w.Body.String())
+}
+
+func TestResponseCacheBypass(t *testing.T) {
+	c, err := NewResponseCache(8, time.Minute)
+	assert.NoError(t, err)
+	srv := NewServer(memRepo{INIT_COMMIT + "::hi.txt": "hi"})
+	srv.AdminSecret = "s3cret"
+	srv.Maintenance = &Maintenance{}
+	h := c.Handler(srv.Handler())
+	get := func(url, auth string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", url, nil)
+		if len(auth) > 0 {
+			r.Header.Set("Authorization", auth)
+		}
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	// an authorized admin response is never served to others
+	assert.Equal(t, http.StatusOK, get("/_admin/maintenance", "Bearer s3cret").Code)
+	assert.Equal(t, http.StatusUnauthorized, get("/_admin/maintenance", "").Code)
+	assert.Equal(t, http.StatusUnauthorized, get("/_admin/maintenance", "").Code)
+	get("/raw/"+INIT_COMMIT+"/hi.txt", "Bearer x")
+	assert.Equal(t, "MISS", get("/raw/"+INIT_COMMIT+"/hi.txt", "").Header().Get("X-Cache"))
+
+	// flushed responses are written through and not cached
+	flushed := c.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("a"))
+		w.(http.Flusher).Flush()
+		w.Write([]byte("b"))
+	}))
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		flushed.ServeHTTP(w, httptest.NewRequest("GET", "/stream", nil))
+		assert.Equal(t, "ab", w.Body.String())
+		assert.True(t, w.Flushed)
+		assert.Empty(t, w.Header().Get("X-Cache"))
+	}
+}
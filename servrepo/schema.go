@@ -0,0 +1,80 @@
+package servrepo
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/xeipuuv/gojsonschema"
+)
+
+// SchemaSuffix is appended to the path of a template to find the JSON schema
+// of its data, e.g. hi.txt.schema.json for hi.txt.
+const SchemaSuffix = ".schema.json"
+
+// noSchema is cached for the templates without a schema.
+var noSchema = &gojsonschema.Schema{}
+
+// schemaOf returns the schema of the template at ref, or nil if it has none.
+// Schemas live in commits, so they're cached for good like templates.
+func (s *Server) schemaOf(ref FileRef) (*gojsonschema.Schema, error) {
+	ref = FileRef{CommitHash: ref.CommitHash, FilePath: effectivePath(ref.FilePath) + SchemaSuffix}
+	key := ref.String()
+	if s.schemas != nil {
+		if cached, ok := s.schemas.Get(key); ok {
+			if cached == noSchema {
+				return nil, nil
+			}
+			return cached.(*gojsonschema.Schema), nil
+		}
+	}
+	src, err := s.Sources.ReadSource(ref)
+	if err == ErrFileNotFound {
+		if s.schemas != nil {
+			s.schemas.Add(key, noSchema)
+		}
+		return nil, nil
+	}
+	if err == ErrFileForbidden {
+		// skipping the validation would let any data through unnoticed
+		return nil, fmt.Errorf("read schema %s: %v", ref.FilePath, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	schema, err := gojsonschema.NewSchema(gojsonschema.NewBytesLoader(src))
+	if err != nil {
+		return nil, fmt.Errorf("load schema %s: %v", ref.FilePath, err)
+	}
+	if s.schemas != nil {
+		s.schemas.Add(key, schema)
+	}
+	return schema, nil
+}
+
+// validateData validates data against the schema of the template at ref, if
+// there is one. The reserved request key and the seed aren't part of the
+// validated data.
+func (s *Server) validateData(ref FileRef, data map[string]interface{}) (invalid, err error) {
+	schema, err := s.schemaOf(ref)
+	if err != nil || schema == nil {
+		return nil, err
+	}
+	doc := make(map[string]interface{}, len(data))
+	for k, v := range data {
+		if k != RequestKey && k != SeedKey && k != NowKey {
+			doc[k] = v
+		}
+	}
+	result, err := schema.Validate(gojsonschema.NewGoLoader(doc))
+	if err != nil {
+		return nil, err
+	}
+	if result.Valid() {
+		return nil, nil
+	}
+	msgs := make([]string, len(result.Errors()))
+	for i, e := range result.Errors() {
+		msgs[i] = e.String()
+	}
+	return fmt.Errorf("invalid data: %s", strings.Join(msgs, "; ")), nil
+}
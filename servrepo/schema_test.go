@@ -0,0 +1,65 @@
+package servrepo
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateData(t *testing.T) {
+	repo := memRepo{
+		INIT_COMMIT + "::hi.txt":              "Hi, {{ .who }}!",
+		INIT_COMMIT + "::hi.txt.schema.json":  `{"type": "object", "required": ["who"], "properties": {"who": {"type": "string", "pattern": "^[a-z]+$"}}}`,
+		INIT_COMMIT + "::free.txt":            "{{ .x }}",
+		INIT_COMMIT + "::bad.txt":             "{{ .x }}",
+		INIT_COMMIT + "::bad.txt.schema.json": `{"type": `,
+	}
+	s := server(repo, func(s *Server) {
+		s.Sources = repo
+		s.ValidateData = true
+	})
+	defer s.Close()
+	get := func(path string) (int, string) {
+		resp, err := http.Get(s.URL + "/raw/" + INIT_COMMIT + "/" + path)
+		assert.NoError(t, err)
+		body, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	status, body := get("hi.txt?who=world")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "Hi, world!", body)
+
+	status, body = get("hi.txt?who=World")
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Contains(t, body, "invalid data: who: Does not match pattern")
+
+	status, _ = get("free.txt?x=anything")
+	assert.Equal(t, http.StatusOK, status)
+
+	status, _ = get("bad.txt?x=1")
+	assert.Equal(t, http.StatusInternalServerError, status)
+
+	// a schema forbidden by the allow-list fails instead of skipping the check
+	s = server(repo, func(s *Server) {
+		s.Sources = forbiddenSchemas{repo}
+		s.ValidateData = true
+	})
+	defer s.Close()
+	status, body = get("hi.txt?who=World")
+	assert.Equal(t, http.StatusInternalServerError, status)
+	assert.NotContains(t, body, "World")
+}
+
+// forbiddenSchemas forbids the schemas like an allow-list without .json.
+type forbiddenSchemas struct{ memRepo }
+
+func (r forbiddenSchemas) ReadSource(ref FileRef) ([]byte, error) {
+	if strings.HasSuffix(ref.FilePath, SchemaSuffix) {
+		return nil, ErrFileForbidden
+	}
+	return r.memRepo.ReadSource(ref)
+}
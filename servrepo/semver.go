@@ -0,0 +1,195 @@
+package servrepo
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"srcd.works/go-git.v4/plumbing"
+)
+
+// ErrNoMatchingTag is returned for a version no tag of the repo satisfies.
+var ErrNoMatchingTag = errors.New("no tag matches the version")
+
+// TagLister lists the tags of a repo with the commits they point to.
+type TagLister interface {
+	TagCommits() (map[string]string, error)
+}
+
+// TagCommits returns the commits the tags point to by tag name, annotated
+// tags are peeled to their commits.
+func (r *GitTmplRepo) TagCommits() (map[string]string, error) {
+	refs, err := r.References()
+	if err != nil {
+		return nil, err
+	}
+	tags := make(map[string]string)
+	err = refs.ForEach(func(ref *plumbing.Reference) error {
+		name := ref.Name().String()
+		if !strings.HasPrefix(name, "refs/tags/") {
+			return nil
+		}
+		hash, err := r.ResolveRef(name)
+		if err != nil {
+			return err
+		}
+		tags[strings.TrimPrefix(name, "refs/tags/")] = hash
+		return nil
+	})
+	return tags, err
+}
+
+// semver is a version like v1.2.3 or v1.2.3-rc.1, the build metadata after
+// '+' is dropped.
+type semver struct {
+	major, minor, patch int
+	pre                 string
+}
+
+// parseSemver parses a tag like v1.2.3, the leading 'v' is optional.
+func parseSemver(tag string) (semver, bool) {
+	s := strings.TrimPrefix(tag, "v")
+	if i := strings.IndexByte(s, '+'); i >= 0 {
+		s = s[:i]
+	}
+	var v semver
+	if i := strings.IndexByte(s, '-'); i >= 0 {
+		s, v.pre = s[:i], s[i+1:]
+		if len(v.pre) == 0 {
+			return v, false
+		}
+	}
+	parts := strings.Split(s, ".")
+	if len(parts) != 3 {
+		return v, false
+	}
+	nums := []*int{&v.major, &v.minor, &v.patch}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 || (len(part) > 1 && part[0] == '0') {
+			return v, false
+		}
+		*nums[i] = n
+	}
+	return v, true
+}
+
+// less orders versions by precedence, a pre-release comes before the
+// release of the same version. Pre-releases are compared as strings.
+func (v semver) less(o semver) bool {
+	switch {
+	case v.major != o.major:
+		return v.major < o.major
+	case v.minor != o.minor:
+		return v.minor < o.minor
+	case v.patch != o.patch:
+		return v.patch < o.patch
+	case v.pre == o.pre:
+		return false
+	case len(v.pre) == 0 || len(o.pre) == 0:
+		return len(v.pre) > 0
+	default:
+		return v.pre < o.pre
+	}
+}
+
+// versionRange is a version whose trailing parts may be wildcards, e.g.
+// v1.2.x or v1, see parseVersionRange.
+type versionRange struct {
+	// parts are the major, minor and patch numbers, -1 matches any.
+	parts [3]int
+	pre   string
+}
+
+// parseVersionRange parses a version like v1.2.3, or a range like v1.2.x,
+// v1.x or v1 matching all the versions with the given leading parts. Only a
+// full version may carry a pre-release.
+func parseVersionRange(s string) (versionRange, error) {
+	if v, ok := parseSemver(s); ok {
+		return versionRange{parts: [3]int{v.major, v.minor, v.patch}, pre: v.pre}, nil
+	}
+	c := versionRange{parts: [3]int{-1, -1, -1}}
+	parts := strings.Split(strings.TrimPrefix(s, "v"), ".")
+	if len(parts) > 3 {
+		return c, fmt.Errorf("invalid version %q, expect e.g. v1.2.3 or v1.2.x", s)
+	}
+	wildcard := false
+	for i, part := range parts {
+		if part == "x" || part == "X" || part == "*" {
+			wildcard = true
+			continue
+		}
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 || wildcard {
+			return c, fmt.Errorf("invalid version %q, expect e.g. v1.2.3 or v1.2.x", s)
+		}
+		c.parts[i] = n
+	}
+	if c.parts[0] < 0 {
+		return c, fmt.Errorf("invalid version %q, the major version is required", s)
+	}
+	return c, nil
+}
+
+// match tells whether v is in the range. A pre-release is only matched by a
+// version naming it.
+func (c versionRange) match(v semver) bool {
+	for i, n := range []int{v.major, v.minor, v.patch} {
+		if c.parts[i] >= 0 && c.parts[i] != n {
+			return false
+		}
+	}
+	return v.pre == c.pre
+}
+
+// matchTag returns the commit of the tag with the highest version in the
+// range of s. Tags which aren't versions are ignored.
+func matchTag(tags map[string]string, s string) (string, error) {
+	c, err := parseVersionRange(s)
+	if err != nil {
+		return "", err
+	}
+	var (
+		best   semver
+		commit string
+	)
+	for tag, hash := range tags {
+		v, ok := parseSemver(tag)
+		if !ok || !c.match(v) {
+			continue
+		}
+		if len(commit) == 0 || best.less(v) {
+			best, commit = v, hash
+		}
+	}
+	if len(commit) == 0 {
+		return "", ErrNoMatchingTag
+	}
+	return commit, nil
+}
+
+// ExtractRefBySemver returns an extractor which takes a version or a range
+// like v1.2.x from the "semver" var of the route and pairs the file path of
+// the "path" var with the commit of the highest matching tag. Refs are made of
+// the commits, so cached templates are shared with requests by hash.
+func ExtractRefBySemver(tags TagLister) func(r *http.Request) (FileRef, error) {
+	return func(r *http.Request) (FileRef, error) {
+		vars := mux.Vars(r)
+		path, ok := vars["path"]
+		if !ok {
+			return FileRef{}, errors.New("route has no \"path\" var")
+		}
+		all, err := tags.TagCommits()
+		if err != nil {
+			return FileRef{}, err
+		}
+		hash, err := matchTag(all, vars["semver"])
+		if err != nil {
+			return FileRef{}, err
+		}
+		return FileRef{CommitHash: hash, FilePath: path}, nil
+	}
+}
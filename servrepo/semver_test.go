@@ -0,0 +1,110 @@
+package servrepo
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"srcd.works/go-git.v4"
+)
+
+type tagListerFunc func() (map[string]string, error)
+
+func (f tagListerFunc) TagCommits() (map[string]string, error) { return f() }
+
+func TestParseSemver(t *testing.T) {
+	v, ok := parseSemver("v1.2.3-rc.1+build")
+	assert.True(t, ok)
+	assert.Equal(t, semver{1, 2, 3, "rc.1"}, v)
+	for _, tag := range []string{"v1.2", "v1.2.x", "v01.2.3", "release", "v1.2.3-"} {
+		_, ok = parseSemver(tag)
+		assert.False(t, ok, tag)
+	}
+	assert.True(t, semver{1, 2, 3, "rc.1"}.less(semver{1, 2, 3, ""}))
+	assert.True(t, semver{1, 2, 3, ""}.less(semver{1, 10, 0, ""}))
+}
+
+func TestMatchTag(t *testing.T) {
+	tags := map[string]string{
+		"v1.2.3":      "a",
+		"v1.2.10":     "b",
+		"v1.3.0":      "c",
+		"v1.4.0-rc.1": "d",
+		"v2.0.0":      "e",
+		"latest":      "f",
+	}
+	for constraint, commit := range map[string]string{
+		"v1.2.3":      "a",
+		"1.2.3":       "a",
+		"v1.2.x":      "b",
+		"v1.2":        "b",
+		"v1.x":        "c",
+		"v1":          "c",
+		"v1.4.0-rc.1": "d",
+		"v2.x.x":      "e",
+	} {
+		hash, err := matchTag(tags, constraint)
+		assert.NoError(t, err, constraint)
+		assert.Equal(t, commit, hash, constraint)
+	}
+	_, err := matchTag(tags, "v3.x")
+	assert.Equal(t, ErrNoMatchingTag, err)
+	_, err = matchTag(tags, "v1.4.x")
+	assert.Equal(t, ErrNoMatchingTag, err)
+	for _, constraint := range []string{"x", "v1.x.2", "latest", "v1.2.3.4"} {
+		_, err = matchTag(tags, constraint)
+		assert.Error(t, err, constraint)
+		assert.NotEqual(t, ErrNoMatchingTag, err, constraint)
+	}
+}
+
+func TestSemverRoutes(t *testing.T) {
+	other := strings.Repeat("1", 40)
+	s := server(memRepo{
+		INIT_COMMIT + "::hi.txt": "v1",
+		other + "::hi.txt":       "v2",
+	}, func(s *Server) {
+		s.Tags = tagListerFunc(func() (map[string]string, error) {
+			return map[string]string{"v1.0.0": INIT_COMMIT, "v1.1.0": other}, nil
+		})
+	})
+	defer s.Close()
+	get := func(path string) (int, string) {
+		resp, err := http.Get(s.URL + path)
+		assert.NoError(t, err)
+		body, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	status, body := get("/v/v1.0.0/raw/hi.txt")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "v1", body)
+	status, body = get("/v/v1.x/raw/hi.txt")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "v2", body)
+	status, _ = get("/v/v2.x/raw/hi.txt")
+	assert.Equal(t, http.StatusNotFound, status)
+	status, _ = get("/v/nope/md5/hi.txt")
+	assert.Equal(t, http.StatusBadRequest, status)
+}
+
+func TestTagCommits(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tags")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	runGit(t, dir, "init", "-q")
+	runGit(t, dir, "commit", "-q", "--allow-empty", "-m", "first")
+	runGit(t, dir, "tag", "v1.0.0")
+	runGit(t, dir, "commit", "-q", "--allow-empty", "-m", "second")
+	runGit(t, dir, "tag", "-a", "-m", "release", "v1.1.0")
+	head := runGit(t, dir, "rev-parse", "HEAD")
+
+	local, err := git.PlainOpen(dir)
+	assert.NoError(t, err)
+	tags, err := (&GitTmplRepo{Repository: local}).TagCommits()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"v1.0.0": runGit(t, dir, "rev-parse", "HEAD~1"), "v1.1.0": head}, tags)
+}
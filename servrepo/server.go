@@ -0,0 +1,287 @@
+// Package servrepo serves rendered templates from a git repo over http.
+package servrepo
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"runtime/debug"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/gorilla/mux"
+	lru "github.com/hashicorp/golang-lru"
+)
+
+var hashRegexp = regexp.MustCompile("^[0-9A-Fa-f]{40}$")
+
+// HashPattern and PathPattern are the mux patterns of the commit hash and the
+// file path vars in the routes.
+const (
+	HashPattern = "{hash:[0-9A-Fa-f]{40}}"
+	PathPattern = "{path:.*}"
+)
+
+// Server serves the templates of Repo, it holds the settings shared by the
+// handlers.
+type Server struct {
+	Repo TmplRepo
+
+	// Files, RawFiles, Sources and Remote back the /ls and /manifest, /file,
+	// /lint and /ping/git routes, which are only registered if set.
+	Files        FileLister
+	RawFiles     RawFileReader
+	Sources      SourceReader
+	Remote       RemoteLister
+	PingInterval time.Duration
+	// ReadyCache is checked by /readyz with a round-trip of a sentinel
+	// template if it's set, otherwise /readyz only tells the server is up.
+	ReadyCache CacheChecker
+
+	// Blobs tells the blob hashes sent in the X-Blob-Hash header of /raw
+	// and /md5 responses, which is left out if nil.
+	Blobs BlobHasher
+
+	// ErrorPages renders error responses, nil means plain text.
+	ErrorPages ErrorPages
+	// CoerceData stores query values looking like numbers or booleans as
+	// typed values, see coerce for the rules.
+	CoerceData bool
+	// CaseInsensitiveKeys lowercases all query keys, so templates have to
+	// refer to them in lowercase. Keys differing only by case are merged if
+	// they carry the same value and rejected otherwise.
+	CaseInsensitiveKeys bool
+	// ExposeHeaders lists the request headers templates can read under the
+	// reserved request key, see RequestKey. Other headers are never exposed.
+	ExposeHeaders []string
+	// HeaderDataPrefix enables data from the request headers starting with
+	// it, e.g. "X-Tmpl-", see headerKey for the naming. Empty disables it.
+	HeaderDataPrefix string
+	// HeaderDataOverride lets header data win over query values of the same
+	// key, query values win otherwise.
+	HeaderDataOverride bool
+	// MaxBodyBytes caps the decompressed size of a gzipped request body
+	// carrying form data, 0 means DefaultMaxBodyBytes. Plain bodies are
+	// capped by net/http.
+	MaxBodyBytes int64
+	// PathVars maps vars of the route to data keys, so a route like
+	// /greet/{name} passes data in the path. Path vars win over query values
+	// and header data of the same key.
+	PathVars map[string]string
+	// PassthroughOnParseError serves the source of a file failing to parse
+	// as a template as is instead of failing, as text/plain unless its
+	// extension tells otherwise. Sources are read from Sources.
+	PassthroughOnParseError bool
+	// RenderTimeout is the budget of rendering a request, functions like
+	// include fail once it's exhausted and the request gets a 504. 0 means
+	// no limit.
+	RenderTimeout time.Duration
+	// ErrorOnEmpty fails a render producing no output with a 422, which
+	// often means no branch of an all-conditional template matched.
+	ErrorOnEmpty bool
+	// FooterComment is the comment prefix of ?footer= lines used when the
+	// syntax can't be told from the file extension.
+	FooterComment string
+	// ServerTiming adds a Server-Timing header breaking the time spent on
+	// rendering down into phases, which exposes internal timing.
+	ServerTiming bool
+	// FileMarker splits the output of /zip into files.
+	FileMarker FileMarker
+	// ValidateData validates the data of a template against the JSON schema
+	// next to it, see SchemaSuffix. Schemas are read from Sources.
+	ValidateData bool
+	// AdminSecret guards the /_admin routes, which are only registered if
+	// it's set. Admin requests carry it as a bearer token.
+	AdminSecret string
+	// Maintenance is switched by /_admin/maintenance, which is registered if
+	// it's set besides AdminSecret.
+	Maintenance *Maintenance
+	// CacheKeys backs /_admin/cache/keys, which is registered if it's set
+	// besides AdminSecret.
+	CacheKeys KeyLister
+	// CacheEvictions is reported at /metrics besides RefRequests if it's
+	// set.
+	CacheEvictions EvictionCounter
+	// ParseCheckExt lists the extensions of the files /_admin/parse-check
+	// parses unless asked for others, e.g. .tmpl, empty means all files. The
+	// route is registered if Files and Sources are set besides AdminSecret.
+	ParseCheckExt []string
+	// Logs backs /_admin/logs, which is registered if it's set besides
+	// AdminSecret. It has to be the output of the log package.
+	Logs *LogBuffer
+	// Whoami backs /_admin/whoami, which is registered if it's set besides
+	// AdminSecret.
+	Whoami WhoamiReporter
+	// History backs /history, which is only registered if set.
+	History HistoryReader
+	// Tags backs the /v/{semver}/raw and /v/{semver}/md5 routes serving the
+	// commit of the highest tag matching a version, which are only
+	// registered if set.
+	Tags TagLister
+
+	// Layout is the path of a layout in the commit of the request, which
+	// the requested template is rendered into, see LayoutContent. Requests
+	// opt out by ?layout=false.
+	Layout string
+	// LocalLayout is a layout loaded from a local file, see LoadLayout,
+	// which takes the place of Layout if set.
+	LocalLayout *template.Template
+
+	// RefRequests counts the requests per template ref, which are served
+	// at /metrics if it's set.
+	RefRequests *RefCounter
+
+	schemas *lru.Cache
+	layouts *lru.Cache
+}
+
+// NewServer returns a server of repo with the default settings.
+func NewServer(repo TmplRepo) *Server {
+	schemas, _ := lru.New(1024)
+	layouts, _ := lru.New(1024)
+	return &Server{
+		schemas:       schemas,
+		layouts:       layouts,
+		Repo:          repo,
+		PingInterval:  10 * time.Second,
+		FooterComment: "# ",
+		FileMarker:    DefaultFileMarker,
+	}
+}
+
+func (s *Server) maxBodyBytes() int64 {
+	if s.MaxBodyBytes > 0 {
+		return s.MaxBodyBytes
+	}
+	return DefaultMaxBodyBytes
+}
+
+// Register registers the routes of the server to r.
+func (s *Server) Register(r *mux.Router) {
+	r.Path(fmt.Sprintf("/raw/%s/%s", HashPattern, PathPattern)).HandlerFunc(s.RawHandler(ExtractRefFromMuxVars))
+	r.Path(fmt.Sprintf("/md5/%s/%s", HashPattern, PathPattern)).HandlerFunc(s.MD5Handler(ExtractRefFromMuxVars))
+	r.Path(fmt.Sprintf("/used/%s/%s", HashPattern, PathPattern)).HandlerFunc(s.UsedKeysHandler(ExtractRefFromMuxVars))
+	r.Path(fmt.Sprintf("/checksums/%s/%s", HashPattern, PathPattern)).HandlerFunc(s.ChecksumsHandler(ExtractRefFromMuxVars))
+	r.Path(fmt.Sprintf("/assert/%s/%s", HashPattern, PathPattern)).HandlerFunc(s.AssertHandler(ExtractRefFromMuxVars))
+	r.Path(fmt.Sprintf("/zip/%s/%s", HashPattern, PathPattern)).HandlerFunc(s.ZipHandler(ExtractRefFromMuxVars))
+	if s.Files != nil {
+		r.Path(fmt.Sprintf("/ls/%s/%s", HashPattern, PathPattern)).HandlerFunc(s.LsHandler(s.Files, ExtractRefFromMuxVars))
+		r.Path(fmt.Sprintf("/manifest/%s/%s", HashPattern, PathPattern)).HandlerFunc(s.ManifestHandler(s.Files, ExtractRefFromMuxVars))
+	}
+	if s.History != nil {
+		r.Path(fmt.Sprintf("/history/%s/%s", HashPattern, PathPattern)).HandlerFunc(s.HistoryHandler(s.History, ExtractRefFromMuxVars))
+	}
+	if s.RawFiles != nil {
+		r.Path(fmt.Sprintf("/file/%s/%s", HashPattern, PathPattern)).HandlerFunc(s.FileHandler(s.RawFiles, ExtractRefFromMuxVars))
+	}
+	if s.Sources != nil {
+		r.Path(fmt.Sprintf("/lint/%s/%s", HashPattern, PathPattern)).HandlerFunc(s.LintHandler(s.Sources, ExtractRefFromMuxVars))
+	}
+	if s.Tags != nil {
+		r.Path("/v/{semver}/raw/" + PathPattern).HandlerFunc(s.RawHandler(ExtractRefBySemver(s.Tags)))
+		r.Path("/v/{semver}/md5/" + PathPattern).HandlerFunc(s.MD5Handler(ExtractRefBySemver(s.Tags)))
+	}
+	r.Path("/readyz").HandlerFunc(ReadyHandler(s.ReadyCache))
+	if s.RefRequests != nil {
+		r.Path("/metrics").Methods("GET").HandlerFunc(MetricsHandler(s.RefRequests, s.CacheEvictions))
+	}
+	if s.Remote != nil {
+		r.Path("/ping/git").HandlerFunc(PingGitHandler(s.Remote, s.PingInterval))
+	}
+	if len(s.AdminSecret) > 0 {
+		r.Path("/_admin/prefetch").Methods("POST").HandlerFunc(s.adminHandler(s.PrefetchHandler()))
+		if s.CacheKeys != nil {
+			r.Path("/_admin/cache/keys").Methods("GET").HandlerFunc(s.adminHandler(s.CacheKeysHandler(s.CacheKeys)))
+		}
+		if s.Files != nil && s.Sources != nil {
+			r.Path("/_admin/parse-check/" + HashPattern).Methods("GET").HandlerFunc(s.adminHandler(s.ParseCheckHandler(s.Files, s.Sources)))
+		}
+		if s.Logs != nil {
+			r.Path("/_admin/logs").Methods("GET").HandlerFunc(s.adminHandler(s.LogsHandler(s.Logs)))
+		}
+		if s.Whoami != nil {
+			r.Path("/_admin/whoami").Methods("GET").HandlerFunc(s.adminHandler(s.WhoamiHandler(s.Whoami)))
+		}
+		if s.Maintenance != nil {
+			r.Path("/_admin/maintenance").Methods("GET", "POST").HandlerFunc(s.adminHandler(s.MaintenanceAdminHandler()))
+		}
+	}
+}
+
+// Handler returns a router with the routes of the server registered, a panic
+// while serving is answered with a 500, see RecoverHandler.
+func (s *Server) Handler() http.Handler {
+	r := mux.NewRouter()
+	s.Register(r)
+	r.NotFoundHandler = s.NotFoundHandler()
+	return RecoverHandler(r)
+}
+
+var hashPrefixes = []string{"/raw/", "/md5/", "/checksums/", "/assert/", "/used/", "/zip/", "/ls/", "/manifest/", "/history/", "/file/", "/lint/"}
+
+// NotFoundHandler explains why a request to a route taking a hash didn't
+// match with a 400, if the hash isn't made of 40 hex chars. Other requests get
+// a plain 404.
+func (s *Server) NotFoundHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, prefix := range hashPrefixes {
+			if !strings.HasPrefix(r.URL.Path, prefix) {
+				continue
+			}
+			hash := strings.SplitN(strings.TrimPrefix(r.URL.Path, prefix), "/", 2)[0]
+			if !hashRegexp.MatchString(hash) {
+				s.checkFailure(fmt.Errorf("invalid commit hash %q, expect 40 hex chars", hash), http.StatusBadRequest, w)
+				return
+			}
+		}
+		s.checkFailure(errors.New("no such route"), http.StatusNotFound, w)
+	})
+}
+
+// RawHandler serves the rendered template with the default settings.
+func RawHandler(repo TmplRepo, extract func(r *http.Request) (FileRef, error)) http.HandlerFunc {
+	return NewServer(repo).RawHandler(extract)
+}
+
+// MD5Handler serves the md5 of the rendered template with the default
+// settings.
+func MD5Handler(repo TmplRepo, extract func(r *http.Request) (FileRef, error)) http.HandlerFunc {
+	return NewServer(repo).MD5Handler(extract)
+}
+
+// MaxPathHandler rejects requests whose path is longer than max bytes.
+func MaxPathHandler(max int, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if max > 0 && len(r.URL.Path) > max {
+			checkFailure(fmt.Errorf("path is longer than %d bytes", max), http.StatusRequestURITooLong, w)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// ErrInternal is the 500 error of a request whose handler panicked.
+var ErrInternal = errors.New("internal server error")
+
+// RecoverHandler answers a request whose handler panics with a 500 and logs
+// the panic with its stack, rather than dropping the connection. The panic
+// value is left out of the response since it may tell about the internals.
+func RecoverHandler(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			p := recover()
+			if p == nil {
+				return
+			}
+			if p == http.ErrAbortHandler {
+				panic(p)
+			}
+			log.Printf("panic serving %s: %v\n%s", r.URL.Path, p, debug.Stack())
+			checkFailure(ErrInternal, http.StatusInternalServerError, w)
+		}()
+		handler.ServeHTTP(w, r)
+	})
+}
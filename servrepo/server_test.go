@@ -0,0 +1,40 @@
+package servrepo
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMaxPathHandler(t *testing.T) {
+	srv := NewServer(repo(t, "..", 32))
+	s := httptest.NewServer(MaxPathHandler(64, srv.Handler()))
+	defer s.Close()
+
+	resp, err := http.Get(s.URL + "/raw/" + INIT_COMMIT + "/templates/hi.txt?who=world")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, err = http.Get(s.URL + "/raw/" + INIT_COMMIT + "/" + strings.Repeat("a/", 32) + "hi.txt?who=world")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusRequestURITooLong, resp.StatusCode)
+}
+
+func TestNotFoundHandler(t *testing.T) {
+	s := server(repo(t, "..", 32))
+	defer s.Close()
+
+	for path, status := range map[string]int{
+		"/raw/" + INIT_COMMIT[:39] + "/templates/hi.txt": http.StatusBadRequest,
+		"/md5/not-a-hash/templates/hi.txt":               http.StatusBadRequest,
+		"/raw/" + INIT_COMMIT:                            http.StatusNotFound,
+		"/nope/" + INIT_COMMIT + "/templates/hi.txt":     http.StatusNotFound,
+	} {
+		resp, err := http.Get(s.URL + path)
+		assert.NoError(t, err)
+		assert.Equal(t, status, resp.StatusCode, path)
+	}
+}
@@ -0,0 +1,62 @@
+package servrepo
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// streamedItem is a line of a streamed multi-path render, holding either the
+// output or the error and status of the path.
+type streamedItem struct {
+	Index  int    `json:"index"`
+	Path   string `json:"path"`
+	Output string `json:"output,omitempty"`
+	Error  string `json:"error,omitempty"`
+	Status int    `json:"status,omitempty"`
+}
+
+// renderStream renders every path of the commit with the same data like
+// renderMulti, but writes a line of json per path as soon as it's rendered
+// and flushes it, so outputs aren't held until the last path is done. A path
+// failing gets a line with its error, the others are rendered regardless.
+func (s *Server) renderStream(commit string, paths []string, data map[string]interface{}, w http.ResponseWriter, r *http.Request) {
+	if len(paths) > MaxMultiPaths {
+		s.checkFailure(fmt.Errorf("too many paths, at most %d are allowed", MaxMultiPaths), http.StatusBadRequest, w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+	for i, p := range paths {
+		item := streamedItem{Index: i, Path: p}
+		fw := &failureWriter{header: make(http.Header)}
+		if out, ok := s.renderFile(FileRef{CommitHash: commit, FilePath: p}, "", data, fw, r); ok {
+			item.Output = string(out)
+		} else {
+			item.Error, item.Status = fw.err.Error(), fw.status
+		}
+		if err := enc.Encode(item); err != nil {
+			// the client is gone
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+}
+
+// failureWriter is passed to the helpers writing failures to w, like
+// renderFile, to record the failure instead, see Server.checkFailure.
+// Anything else written to it is dropped.
+type failureWriter struct {
+	header http.Header
+	err    error
+	status int
+}
+
+func (w *failureWriter) Header() http.Header { return w.header }
+
+func (w *failureWriter) WriteHeader(status int) {}
+
+func (w *failureWriter) Write(p []byte) (int, error) { return len(p), nil }
@@ -0,0 +1,124 @@
+package servrepo
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+
+	"srcd.works/go-billy.v1/osfs"
+	"srcd.works/go-git.v4"
+	"srcd.works/go-git.v4/config"
+	"srcd.works/go-git.v4/plumbing/object"
+	"srcd.works/go-git.v4/storage/filesystem"
+)
+
+// SubmoduleOpener returns an OpenSubmodule opening the submodules kept in the
+// git dir, i.e. {gitDir}/modules/{name} as set up by git submodule update. A
+// name leaving the modules dir is rejected.
+func SubmoduleOpener(gitDir string) func(name string) (*git.Repository, error) {
+	return func(name string) (*git.Repository, error) {
+		if !validSubmoduleName(name) {
+			return nil, fmt.Errorf("invalid submodule name %q", name)
+		}
+		fs := osfs.New(filepath.Join(gitDir, "modules", name))
+		s, err := filesystem.NewStorage(fs)
+		if err != nil {
+			return nil, err
+		}
+		// only objects are read, the worktree is never touched
+		return git.Open(s, fs)
+	}
+}
+
+// findSubmoduleFile finds the file at filePath of the commit when it's under
+// a submodule, the submodule is looked up in the repo opened by
+// OpenSubmodule. A submodule which isn't initialized, or lacks the commit the
+// superproject refers to, has no files.
+func (r *GitTmplRepo) findSubmoduleFile(commit *object.Commit, filePath string) (*object.File, error) {
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, ErrFileNotFound
+	}
+	parts := strings.Split(filePath, "/")
+	for i, part := range parts[:len(parts)-1] {
+		entry := treeEntry(tree, part)
+		if entry == nil {
+			return nil, ErrFileNotFound
+		}
+		if entry.Mode != object.SubmoduleMode {
+			if tree, err = tree.Tree(part); err != nil {
+				return nil, ErrFileNotFound
+			}
+			continue
+		}
+		subPath := strings.Join(parts[:i+1], "/")
+		name, sub, err := r.openSubmodule(commit, subPath)
+		if err != nil {
+			log.Printf("submodule %s of %s is not initialized: %v", subPath, commit.Hash, err)
+			return nil, ErrFileNotFound
+		}
+		subCommit, err := sub.Commit(entry.Hash)
+		if err != nil {
+			log.Printf("submodule %s lacks commit %s", subPath, entry.Hash)
+			return nil, ErrFileNotFound
+		}
+		rest := strings.Join(parts[i+1:], "/")
+		if file, err := subCommit.File(rest); err == nil {
+			return file, nil
+		}
+		// submodules may be nested, git keeps them in the git dir of the
+		// outer one, i.e. modules/{outer}/modules/{inner}
+		open := r.OpenSubmodule
+		nested := func(inner string) (*git.Repository, error) {
+			return open(name + "/modules/" + inner)
+		}
+		return (&GitTmplRepo{Repository: sub, OpenSubmodule: nested}).findSubmoduleFile(subCommit, rest)
+	}
+	return nil, ErrFileNotFound
+}
+
+func treeEntry(tree *object.Tree, name string) *object.TreeEntry {
+	for i := range tree.Entries {
+		if tree.Entries[i].Name == name {
+			return &tree.Entries[i]
+		}
+	}
+	return nil
+}
+
+// openSubmodule opens the submodule at subPath, which is named after its
+// path unless .gitmodules of the commit tells otherwise, and returns its name.
+func (r *GitTmplRepo) openSubmodule(commit *object.Commit, subPath string) (string, *git.Repository, error) {
+	name := subPath
+	if file, err := commit.File(".gitmodules"); err == nil {
+		if raw, err := file.Contents(); err == nil {
+			modules := config.NewModules()
+			if err := modules.Unmarshal([]byte(raw)); err == nil {
+				if m, ok := modules.Submodules[subPath]; ok && len(m.Name) > 0 {
+					name = m.Name
+				}
+			}
+		}
+	}
+	if !validSubmoduleName(name) {
+		return "", nil, fmt.Errorf("invalid submodule name %q", name)
+	}
+	sub, err := r.OpenSubmodule(name)
+	return name, sub, err
+}
+
+// validSubmoduleName tells whether the name stays under the modules dir, i.e.
+// it's relative and has no .. element. Names come from .gitmodules, which
+// anyone pushing to the repo controls.
+func validSubmoduleName(name string) bool {
+	if len(name) == 0 || filepath.IsAbs(name) || strings.HasPrefix(name, "/") || strings.HasPrefix(name, "\\") {
+		return false
+	}
+	for _, part := range strings.FieldsFunc(name, func(c rune) bool { return c == '/' || c == '\\' }) {
+		if part == ".." {
+			return false
+		}
+	}
+	return true
+}
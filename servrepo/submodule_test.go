@@ -0,0 +1,109 @@
+package servrepo
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"srcd.works/go-git.v4"
+)
+
+func TestSubmodules(t *testing.T) {
+	dir, err := ioutil.TempDir("", "submodules")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	shared, super := filepath.Join(dir, "shared"), filepath.Join(dir, "super")
+	runGit(t, "", "init", "-q", shared)
+	assert.NoError(t, os.MkdirAll(filepath.Join(shared, "tpl"), 0755))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(shared, "tpl", "hi.txt"), []byte("Hi, {{ .who }}!"), 0644))
+	runGit(t, shared, "add", ".")
+	runGit(t, shared, "commit", "-q", "-m", "shared")
+	runGit(t, "", "init", "-q", super)
+	runGit(t, super, "submodule", "add", "-q", "--name", "common", shared, "lib/shared")
+	runGit(t, super, "commit", "-q", "-m", "super")
+	head := runGit(t, super, "rev-parse", "HEAD")
+
+	local, err := git.PlainOpen(super)
+	assert.NoError(t, err)
+	r := &GitTmplRepo{Repository: local}
+	ref := FileRef{CommitHash: head, FilePath: "lib/shared/tpl/hi.txt"}
+	_, err = r.FindFile(ref)
+	assert.Equal(t, ErrFileNotFound, err)
+
+	r.OpenSubmodule = SubmoduleOpener(filepath.Join(super, ".git"))
+	tpl, err := r.GetTemplate(ref, false)
+	if assert.NoError(t, err) {
+		out, err := render(tpl, map[string]interface{}{"who": "world"})
+		assert.NoError(t, err)
+		assert.Equal(t, "Hi, world!", string(out))
+	}
+	_, err = r.FindFile(FileRef{CommitHash: head, FilePath: "lib/shared/tpl/nope.txt"})
+	assert.Equal(t, ErrFileNotFound, err)
+
+	// not initialized
+	assert.NoError(t, os.RemoveAll(filepath.Join(super, ".git", "modules")))
+	_, err = r.FindFile(ref)
+	assert.Equal(t, ErrFileNotFound, err)
+}
+
+func TestNestedSubmodules(t *testing.T) {
+	dir, err := ioutil.TempDir("", "submodules")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	inner, shared, super := filepath.Join(dir, "inner"), filepath.Join(dir, "shared"), filepath.Join(dir, "super")
+	runGit(t, "", "init", "-q", inner)
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(inner, "deep.txt"), []byte("Deep, {{ .who }}!"), 0644))
+	runGit(t, inner, "add", ".")
+	runGit(t, inner, "commit", "-q", "-m", "inner")
+	runGit(t, "", "init", "-q", shared)
+	runGit(t, shared, "submodule", "add", "-q", "--name", "deep", inner, "sub/inner")
+	runGit(t, shared, "commit", "-q", "-m", "shared")
+	runGit(t, "", "init", "-q", super)
+	runGit(t, super, "submodule", "add", "-q", "--name", "common", shared, "lib/shared")
+	runGit(t, super, "submodule", "update", "-q", "--init", "--recursive")
+	runGit(t, super, "commit", "-q", "-m", "super")
+	head := runGit(t, super, "rev-parse", "HEAD")
+
+	local, err := git.PlainOpen(super)
+	assert.NoError(t, err)
+	r := &GitTmplRepo{Repository: local, OpenSubmodule: SubmoduleOpener(filepath.Join(super, ".git"))}
+	_, err = r.FindFile(FileRef{CommitHash: head, FilePath: "lib/shared/sub/inner/deep.txt"})
+	assert.NoError(t, err)
+}
+
+func TestSubmoduleNameOutsideModules(t *testing.T) {
+	dir, err := ioutil.TempDir("", "submodules")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	shared, super := filepath.Join(dir, "shared"), filepath.Join(dir, "super")
+	runGit(t, "", "init", "-q", shared)
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(shared, "hi.txt"), []byte("Hi!"), 0644))
+	runGit(t, shared, "add", ".")
+	runGit(t, shared, "commit", "-q", "-m", "shared")
+	runGit(t, "", "init", "-q", super)
+	runGit(t, super, "submodule", "add", "-q", shared, "lib/shared")
+	// the name points at the git dir of the shared repo, out of .git/modules
+	gitmodules := filepath.Join(super, ".gitmodules")
+	raw, err := ioutil.ReadFile(gitmodules)
+	assert.NoError(t, err)
+	raw = []byte(strings.Replace(string(raw), `"lib/shared"`, `"../../../shared/.git"`, 1))
+	assert.NoError(t, ioutil.WriteFile(gitmodules, raw, 0644))
+	runGit(t, super, "add", ".gitmodules")
+	runGit(t, super, "commit", "-q", "-m", "super")
+	head := runGit(t, super, "rev-parse", "HEAD")
+
+	local, err := git.PlainOpen(super)
+	assert.NoError(t, err)
+	r := &GitTmplRepo{Repository: local, OpenSubmodule: SubmoduleOpener(filepath.Join(super, ".git"))}
+	_, err = r.FindFile(FileRef{CommitHash: head, FilePath: "lib/shared/hi.txt"})
+	assert.Equal(t, ErrFileNotFound, err)
+
+	for _, name := range []string{"/abs", "a/../../b", "..", `a\..\b`} {
+		_, err := SubmoduleOpener(filepath.Join(super, ".git"))(name)
+		assert.Error(t, err, name)
+	}
+}
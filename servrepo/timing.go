@@ -0,0 +1,74 @@
+package servrepo
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Timing collects the time spent in the phases of serving a request, it's
+// rendered as a Server-Timing header. A nil Timing records nothing.
+type Timing struct {
+	names     []string
+	durations map[string]time.Duration
+	// cached tells whether the template was found in a cache, nil if no
+	// cache was involved.
+	cached *bool
+}
+
+// Add adds d to the time spent in the phase.
+func (t *Timing) Add(phase string, d time.Duration) {
+	if t == nil {
+		return
+	}
+	if t.durations == nil {
+		t.durations = make(map[string]time.Duration)
+	}
+	if _, ok := t.durations[phase]; !ok {
+		t.names = append(t.names, phase)
+	}
+	t.durations[phase] += d
+}
+
+// Cached records whether the template was found in a cache.
+func (t *Timing) Cached(hit bool) {
+	if t == nil {
+		return
+	}
+	t.cached = &hit
+}
+
+// Since adds the time elapsed since start to the phase.
+func (t *Timing) Since(phase string, start time.Time) {
+	t.Add(phase, time.Since(start))
+}
+
+// String formats the phases in the order they were first added, with the
+// durations in milliseconds, e.g. "cache;dur=0.012, render;dur=0.3".
+func (t *Timing) String() string {
+	if t == nil {
+		return ""
+	}
+	metrics := make([]string, len(t.names))
+	for i, name := range t.names {
+		metrics[i] = fmt.Sprintf("%s;dur=%.3f", name, float64(t.durations[name])/float64(time.Millisecond))
+	}
+	return strings.Join(metrics, ", ")
+}
+
+// timedTmplRepo is implemented by the repos able to break the time spent in
+// GetTemplate down into phases.
+type timedTmplRepo interface {
+	getTemplateTimed(ref FileRef, sync bool, timing *Timing) (*template.Template, error)
+}
+
+// getTemplateTimed gets the template from repo, recording the phases if repo
+// supports it and the whole call as "template" otherwise.
+func getTemplateTimed(repo TmplRepo, ref FileRef, sync bool, timing *Timing) (*template.Template, error) {
+	if timed, ok := repo.(timedTmplRepo); ok {
+		return timed.getTemplateTimed(ref, sync, timing)
+	}
+	defer timing.Since("template", time.Now())
+	return repo.GetTemplate(ref, sync)
+}
@@ -0,0 +1,46 @@
+package servrepo
+
+import (
+	"net/http"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTiming(t *testing.T) {
+	var nilTiming *Timing
+	nilTiming.Add("x", time.Second)
+	assert.Equal(t, "", nilTiming.String())
+
+	timing := &Timing{}
+	timing.Add("find", time.Millisecond)
+	timing.Add("render", 2*time.Millisecond)
+	timing.Add("find", time.Millisecond)
+	assert.Equal(t, "find;dur=2.000, render;dur=2.000", timing.String())
+}
+
+func TestServerTiming(t *testing.T) {
+	s := server(repo(t, "..", 32), func(s *Server) { s.ServerTiming = true })
+	defer s.Close()
+	url := s.URL + "/raw/" + INIT_COMMIT + "/templates/hi.txt?who=world"
+	phases := func() []string {
+		resp, err := http.Get(url)
+		assert.NoError(t, err)
+		var names []string
+		for _, m := range regexp.MustCompile(`(\w+);dur=`).FindAllStringSubmatch(resp.Header.Get("Server-Timing"), -1) {
+			names = append(names, m[1])
+		}
+		return names
+	}
+
+	assert.Equal(t, []string{"cache", "find", "parse", "render"}, phases())
+	assert.Equal(t, []string{"cache", "render"}, phases())
+
+	s2 := server(repo(t, "..", 32))
+	defer s2.Close()
+	resp, err := http.Get(s2.URL + "/raw/" + INIT_COMMIT + "/templates/hi.txt?who=world")
+	assert.NoError(t, err)
+	assert.Empty(t, resp.Header.Get("Server-Timing"))
+}
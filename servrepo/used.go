@@ -0,0 +1,99 @@
+package servrepo
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// MaxUsedKeys caps the number of data keys /used checks, each one costs a
+// render.
+const MaxUsedKeys = 64
+
+type usedKeys struct {
+	Used   []string `json:"used"`
+	Unused []string `json:"unused"`
+}
+
+// UsedKeysHandler reports which keys of the data the render of the template
+// consumes as json. text/template looks map keys up by reflection, which no
+// map type can intercept, so a key counts as used if the render without it
+// fails or differs. The random and time functions are pinned for all the
+// renders, see SeedKey and NowKey.
+func (s *Server) UsedKeysHandler(extract func(r *http.Request) (FileRef, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		data, ref, ok := s.prepareRequest(extract, w, r)
+		if !ok {
+			return
+		}
+		keys := dataKeys(data, "")
+		if len(keys) > MaxUsedKeys {
+			s.checkFailure(fmt.Errorf("too many keys, at most %d are checked", MaxUsedKeys), http.StatusBadRequest, w)
+			return
+		}
+		if _, ok := data[SeedKey]; !ok {
+			data[SeedKey] = strconv.FormatInt(rand.Int63(), 36)
+		}
+		if _, ok := data[NowKey]; !ok {
+			data[NowKey] = time.Now().Format(time.RFC3339)
+		}
+		name := r.FormValue("template")
+		base, ok := s.renderFile(ref, name, data, w, r)
+		if !ok {
+			return
+		}
+
+		resp := usedKeys{Used: []string{}, Unused: []string{}}
+		for _, key := range keys {
+			fw := &failureWriter{header: make(http.Header)}
+			if out, ok := s.renderFile(ref, name, withoutKey(data, key), fw, r); ok && bytes.Equal(out, base) {
+				resp.Unused = append(resp.Unused, key)
+			} else {
+				resp.Used = append(resp.Used, key)
+			}
+		}
+		s.setBlobHash(w, ref)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	}
+}
+
+// dataKeys returns the dotted keys of the leaves of data, sorted, leaving the
+// reserved keys out.
+func dataKeys(data map[string]interface{}, prefix string) []string {
+	var keys []string
+	for k, v := range data {
+		if len(prefix) == 0 && (k == RequestKey || k == AllKey || k == SeedKey || k == NowKey) {
+			continue
+		}
+		if nested, ok := v.(map[string]interface{}); ok && len(nested) > 0 {
+			keys = append(keys, dataKeys(nested, prefix+k+".")...)
+			continue
+		}
+		keys = append(keys, prefix+k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// withoutKey returns a copy of data without the dotted key, data itself is
+// left untouched.
+func withoutKey(data map[string]interface{}, key string) map[string]interface{} {
+	copied := make(map[string]interface{}, len(data))
+	for k, v := range data {
+		copied[k] = v
+	}
+	for k, v := range data {
+		if k == key {
+			delete(copied, k)
+		} else if nested, ok := v.(map[string]interface{}); ok && len(key) > len(k) && key[:len(k)+1] == k+"." {
+			copied[k] = withoutKey(nested, key[len(k)+1:])
+		}
+	}
+	return copied
+}
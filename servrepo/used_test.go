@@ -0,0 +1,41 @@
+package servrepo
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUsedKeysHandler(t *testing.T) {
+	s := server(memRepo{
+		INIT_COMMIT + "::a.txt": `{{ if .loud }}{{ .greeting }}{{ else }}hi{{ end }} {{ .user.name }} {{ randInt 100 }} {{ now.Unix }}{{ include "b.txt" }}`,
+		INIT_COMMIT + "::b.txt": `{{ .tail }}`,
+	})
+	defer s.Close()
+	get := func(q string) (int, usedKeys) {
+		resp, err := http.Get(s.URL + "/used/" + INIT_COMMIT + "/a.txt" + q)
+		assert.NoError(t, err)
+		var used usedKeys
+		json.NewDecoder(resp.Body).Decode(&used)
+		return resp.StatusCode, used
+	}
+
+	status, used := get("?loud=&greeting=hello&user.name=bob&user.age=30&tail=!&extra=1")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, usedKeys{
+		Used:   []string{"loud", "tail", "user.name"},
+		Unused: []string{"extra", "greeting", "user.age"},
+	}, used)
+
+	status, _ = get("?greeting=hello")
+	assert.Equal(t, http.StatusBadRequest, status)
+}
+
+func TestWithoutKey(t *testing.T) {
+	data := map[string]interface{}{"a": "1", "b": map[string]interface{}{"c": "2", "d": "3"}}
+	assert.Equal(t, map[string]interface{}{"b": map[string]interface{}{"c": "2", "d": "3"}}, withoutKey(data, "a"))
+	assert.Equal(t, map[string]interface{}{"a": "1", "b": map[string]interface{}{"d": "3"}}, withoutKey(data, "b.c"))
+	assert.Len(t, data["b"], 2)
+}
@@ -0,0 +1,67 @@
+package servrepo
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// varyHeaders lists the request headers the output of r depends on, the
+// ExposeHeaders and, with header data, the headers of r carrying data.
+// Headers with the prefix r doesn't carry can't be listed, so clients
+// sharing a cache have to send the same set of data headers.
+func (s *Server) varyHeaders(r *http.Request) []string {
+	seen := make(map[string]bool)
+	var names []string
+	add := func(name string) {
+		name = http.CanonicalHeaderKey(name)
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	for _, name := range s.ExposeHeaders {
+		add(name)
+	}
+	if len(s.HeaderDataPrefix) > 0 {
+		var data []string
+		for name := range r.Header {
+			if _, ok := headerKey(s.HeaderDataPrefix, name); ok {
+				data = append(data, name)
+			}
+		}
+		sort.Strings(data)
+		for _, name := range data {
+			add(name)
+		}
+	}
+	return names
+}
+
+// setVary adds the headers of varyHeaders to the Vary header of w. It's
+// always done, a cache reusing a response for other header values would serve
+// the data of someone else.
+func (s *Server) setVary(w http.ResponseWriter, r *http.Request) {
+	if names := s.varyHeaders(r); len(names) > 0 {
+		w.Header().Add("Vary", strings.Join(names, ", "))
+	}
+}
+
+// varyValues returns the values of r for the headers listed by the Vary
+// header of a response, and false if the response varies on "*", i.e. can't
+// be reused.
+func varyValues(header http.Header, r *http.Request) (string, bool) {
+	var values []string
+	for _, line := range header["Vary"] {
+		for _, name := range strings.Split(line, ",") {
+			name = strings.TrimSpace(name)
+			if name == "*" {
+				return "", false
+			}
+			if len(name) > 0 {
+				values = append(values, name+"="+strings.Join(r.Header[http.CanonicalHeaderKey(name)], ","))
+			}
+		}
+	}
+	return strings.Join(values, "\n"), true
+}
@@ -0,0 +1,50 @@
+package servrepo
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVary(t *testing.T) {
+	srv := NewServer(memRepo{INIT_COMMIT + "::hi.txt": `{{ .who }}|{{ index ._request.headers "X-Request-Id" }}`})
+	srv.ExposeHeaders = []string{"x-request-id"}
+	srv.HeaderDataPrefix = "X-Tmpl-"
+	cache, err := NewResponseCache(8, time.Minute)
+	assert.NoError(t, err)
+	handler := cache.Handler(srv.Handler())
+	get := func(header map[string]string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/raw/"+INIT_COMMIT+"/hi.txt", nil)
+		for k, v := range header {
+			r.Header.Set(k, v)
+		}
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	w := get(map[string]string{"X-Tmpl-Who": "world", "X-Request-Id": "1"})
+	assert.Equal(t, "X-Request-Id, X-Tmpl-Who", w.Header().Get("Vary"))
+	assert.Equal(t, "MISS", w.Header().Get("X-Cache"))
+	w = get(map[string]string{"X-Tmpl-Who": "world", "X-Request-Id": "1"})
+	assert.Equal(t, "HIT", w.Header().Get("X-Cache"))
+	w = get(map[string]string{"X-Tmpl-Who": "there", "X-Request-Id": "1"})
+	assert.Equal(t, "MISS", w.Header().Get("X-Cache"))
+	assert.Equal(t, "there|1", w.Body.String())
+	w = get(map[string]string{"X-Tmpl-Who": "there", "X-Request-Id": "2"})
+	assert.Equal(t, "MISS", w.Header().Get("X-Cache"))
+	assert.Equal(t, "there|2", w.Body.String())
+}
+
+func TestVaryValues(t *testing.T) {
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("X-A", "1")
+	values, ok := varyValues(http.Header{"Vary": {"x-a, X-B", "Origin"}}, r)
+	assert.True(t, ok)
+	assert.Equal(t, "x-a=1\nX-B=\nOrigin=", values)
+	_, ok = varyValues(http.Header{"Vary": {"*"}}, r)
+	assert.False(t, ok)
+}
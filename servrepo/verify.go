@@ -0,0 +1,54 @@
+package servrepo
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// ReadManifest reads the refs listed in the file, one hash::path per line.
+// Blank lines and lines starting with # are skipped.
+func ReadManifest(name string) ([]FileRef, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseManifest(f, name)
+}
+
+// parseManifest parses the manifest read from r, name tells where it comes
+// from in errors.
+func parseManifest(r io.Reader, name string) ([]FileRef, error) {
+	var refs []FileRef
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		pos := strings.Index(line, "::")
+		if pos < 0 || !hashRegexp.MatchString(line[:pos]) || pos+2 == len(line) {
+			return nil, fmt.Errorf("%s:%d: expect hash::path, got %q", name, n, line)
+		}
+		refs = append(refs, FileRef{CommitHash: strings.ToLower(line[:pos]), FilePath: line[pos+2:]})
+	}
+	return refs, scanner.Err()
+}
+
+// Verify checks that every ref can be found in the repo, the error lists all
+// the refs that can't rather than the first one only.
+func (r *GitTmplRepo) Verify(refs []FileRef) error {
+	var failures []string
+	for _, ref := range refs {
+		if _, err := r.FindFile(ref); err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", ref.String(), err))
+		}
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("%d of %d refs can't be found:\n  %s", len(failures), len(refs), strings.Join(failures, "\n  "))
+	}
+	return nil
+}
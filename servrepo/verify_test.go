@@ -0,0 +1,42 @@
+package servrepo
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"srcd.works/go-git.v4"
+)
+
+func TestVerify(t *testing.T) {
+	f, err := ioutil.TempFile("", "manifest")
+	assert.NoError(t, err)
+	defer os.Remove(f.Name())
+	missing := strings.Repeat("0", 40)
+	f.WriteString("# refs to serve\n" +
+		INIT_COMMIT + "::templates/hi.txt\n\n" +
+		INIT_COMMIT + "::templates/nope.txt\n" +
+		missing + "::templates/hi.txt\n")
+	f.Close()
+
+	refs, err := ReadManifest(f.Name())
+	assert.NoError(t, err)
+	assert.Len(t, refs, 3)
+
+	local, err := git.PlainOpen("..")
+	assert.NoError(t, err)
+	r := &GitTmplRepo{Repository: local}
+	assert.NoError(t, r.Verify(refs[:1]))
+	err = r.Verify(refs)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "2 of 3 refs")
+		assert.Contains(t, err.Error(), INIT_COMMIT+"::templates/nope.txt: "+ErrFileNotFound.Error())
+		assert.Contains(t, err.Error(), missing+"::templates/hi.txt: "+ErrCommitNotFound.Error())
+	}
+
+	ioutil.WriteFile(f.Name(), []byte("templates/hi.txt\n"), 0644)
+	_, err = ReadManifest(f.Name())
+	assert.Error(t, err)
+}
@@ -0,0 +1,82 @@
+package servrepo
+
+import (
+	"io/ioutil"
+	"log"
+	"sync"
+
+	"srcd.works/go-git.v4/plumbing"
+	"srcd.works/go-git.v4/plumbing/object"
+)
+
+// worktree holds the files of a commit in memory, so they are read without
+// walking the tree of the commit, see GitTmplRepo.Checkout.
+type worktree struct {
+	mu    sync.RWMutex
+	hash  string
+	files map[string][]byte
+}
+
+// Checkout reads the files of the commit r.CheckoutRef resolves to into
+// memory, unless it's already there. The files are swapped at once, so
+// requests never see a mix of two commits.
+func (r *GitTmplRepo) Checkout() error {
+	hash, err := r.ResolveRef(r.CheckoutRef)
+	if err != nil {
+		return err
+	}
+	r.checkout.mu.RLock()
+	current := r.checkout.hash
+	r.checkout.mu.RUnlock()
+	if hash == current {
+		return nil
+	}
+
+	commit, err := r.Commit(plumbing.NewHash(hash))
+	if err != nil {
+		return ErrCommitNotFound
+	}
+	iter, err := commit.Files()
+	if err != nil {
+		return err
+	}
+	defer iter.Close()
+	files := make(map[string][]byte)
+	err = iter.ForEach(func(f *object.File) error {
+		if !r.allowed(f.Name) {
+			return nil
+		}
+		in, err := f.Reader()
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+		raw, err := ioutil.ReadAll(in)
+		if err != nil {
+			return err
+		}
+		files[f.Name] = raw
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	r.checkout.mu.Lock()
+	r.checkout.hash, r.checkout.files = hash, files
+	r.checkout.mu.Unlock()
+	log.Printf("checked out %d files of %s at %s", len(files), r.CheckoutRef, hash)
+	return nil
+}
+
+// checkedOut returns the file of ref if it's checked out. Files of other
+// commits, or under submodules, aren't.
+func (r *GitTmplRepo) checkedOut(ref FileRef) ([]byte, bool) {
+	r.checkout.mu.RLock()
+	defer r.checkout.mu.RUnlock()
+	if r.checkout.hash != ref.CommitHash {
+		return nil, false
+	}
+	raw, ok := r.checkout.files[ref.FilePath]
+	return raw, ok
+}
@@ -0,0 +1,57 @@
+package servrepo
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"srcd.works/go-git.v4"
+)
+
+func TestCheckout(t *testing.T) {
+	dir, err := ioutil.TempDir("", "checkout")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	commit := func(content string) string {
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "hi.txt"), []byte(content), 0644))
+		runGit(t, dir, "add", "hi.txt")
+		runGit(t, dir, "commit", "-q", "-m", content)
+		return runGit(t, dir, "rev-parse", "HEAD")
+	}
+	runGit(t, dir, "init", "-q")
+	first := commit("Hi, {{ .who }}!")
+	runGit(t, dir, "branch", "pinned")
+
+	local, err := git.PlainOpen(dir)
+	assert.NoError(t, err)
+	r := &GitTmplRepo{Repository: local, CheckoutRef: "pinned"}
+	assert.NoError(t, r.Checkout())
+	raw, ok := r.checkedOut(FileRef{CommitHash: first, FilePath: "hi.txt"})
+	assert.True(t, ok)
+	assert.Equal(t, "Hi, {{ .who }}!", string(raw))
+	src, err := r.ReadSource(FileRef{CommitHash: first, FilePath: "hi.txt"})
+	assert.NoError(t, err)
+	assert.Equal(t, "Hi, {{ .who }}!", string(src))
+
+	// other commits are read from the repo
+	second := commit("Bye, {{ .who }}!")
+	_, ok = r.checkedOut(FileRef{CommitHash: second, FilePath: "hi.txt"})
+	assert.False(t, ok)
+	src, err = r.ReadSource(FileRef{CommitHash: second, FilePath: "hi.txt"})
+	assert.NoError(t, err)
+	assert.Equal(t, "Bye, {{ .who }}!", string(src))
+
+	runGit(t, dir, "branch", "-f", "pinned", second)
+	assert.NoError(t, r.Checkout())
+	_, ok = r.checkedOut(FileRef{CommitHash: first, FilePath: "hi.txt"})
+	assert.False(t, ok)
+	raw, ok = r.checkedOut(FileRef{CommitHash: second, FilePath: "hi.txt"})
+	assert.True(t, ok)
+	assert.Equal(t, "Bye, {{ .who }}!", string(raw))
+
+	r.Deny = []string{"*.txt"}
+	_, err = r.ReadSource(FileRef{CommitHash: second, FilePath: "hi.txt"})
+	assert.Equal(t, ErrFileForbidden, err)
+}